@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"time"
+
+	"github.com/elek/jira-retriever/jiradata"
+)
+
+// StoredAttachment is the metadata of a mirrored attachment. The content is saved only once per SHA-256 hash, the
+// same file attached to multiple issues is referenced by multiple metadata records.
+type StoredAttachment struct {
+	ID       int       `json:"id"`
+	IssueKey string    `json:"issueKey"`
+	Filename string    `json:"filename"`
+	Size     int64     `json:"size"`
+	MimeType string    `json:"mimeType"`
+	Author   string    `json:"author"`
+	Created  time.Time `json:"created"`
+	Sha256   string    `json:"sha256"`
+	Path     string    `json:"path"`
+}
+
+// AttachmentStore is a local content-addressed store of the jira attachments.
+//
+// Layout of the directory:
+//   objects/ab/abcdef...  content of the file, named by the SHA-256 hash
+//   meta/<id>.json        metadata of the attachment with the given jira id
+type AttachmentStore struct {
+	Dir string
+}
+
+func CreateAttachmentStore(dir string) (*AttachmentStore, error) {
+	for _, subdir := range []string{"objects", "meta", "tmp"} {
+		err := os.MkdirAll(path.Join(dir, subdir), os.ModePerm)
+		if err != nil {
+			return nil, err
+		}
+	}
+	log.Print("Mirroring attachments to " + dir)
+	return &AttachmentStore{Dir: dir}, nil
+}
+
+func (store *AttachmentStore) metaFile(id int) string {
+	return path.Join(store.Dir, "meta", fmt.Sprintf("%d.json", id))
+}
+
+// Save downloads the content of the attachment (if it's not yet mirrored) and records the metadata.
+func (store *AttachmentStore) Save(ctx context.Context, client *JiraClient, issueKey string, attachment *jiradata.Attachment,
+	created time.Time) (StoredAttachment, error) {
+	var stored StoredAttachment
+	metaFile := store.metaFile(int(attachment.ID))
+	if content, err := ioutil.ReadFile(metaFile); err == nil {
+		err = json.Unmarshal(content, &stored)
+		return stored, err
+	}
+
+	tmp, err := ioutil.TempFile(path.Join(store.Dir, "tmp"), "download")
+	if err != nil {
+		return stored, err
+	}
+	defer os.Remove(tmp.Name())
+
+	body, err := client.download(ctx, attachment.Content)
+	if err != nil {
+		tmp.Close()
+		return stored, err
+	}
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), body)
+	body.Close()
+	tmp.Close()
+	if err != nil {
+		return stored, err
+	}
+
+	sum := fmt.Sprintf("%x", hash.Sum(nil))
+	objectDir := path.Join(store.Dir, "objects", sum[0:2])
+	objectFile := path.Join(objectDir, sum)
+	if _, err := os.Stat(objectFile); os.IsNotExist(err) {
+		err = os.MkdirAll(objectDir, os.ModePerm)
+		if err != nil {
+			return stored, err
+		}
+		err = os.Rename(tmp.Name(), objectFile)
+		if err != nil {
+			return stored, err
+		}
+	} else {
+		log.Printf("Content of %s is already mirrored as %s", attachment.Filename, sum)
+	}
+
+	stored = StoredAttachment{
+		ID:       int(attachment.ID),
+		IssueKey: issueKey,
+		Filename: attachment.Filename,
+		Size:     size,
+		MimeType: attachment.MimeType,
+		Author:   displayName(attachment.Author),
+		Created:  created,
+		Sha256:   sum,
+		Path:     objectFile,
+	}
+	content, err := json.MarshalIndent(stored, "", "  ")
+	if err != nil {
+		return stored, err
+	}
+	return stored, ioutil.WriteFile(metaFile, content, 0644)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/elek/jira-retriever/jiradata"
+	"github.com/stretchr/testify/assert"
+)
+
+func testAttachmentStore(t *testing.T) (*AttachmentStore, func()) {
+	dir, err := ioutil.TempDir("", "attachments")
+	assert.Nil(t, err)
+	store, err := CreateAttachmentStore(dir)
+	assert.Nil(t, err)
+	return store, func() { os.RemoveAll(dir) }
+}
+
+func testAttachment(id int, filename string) *jiradata.Attachment {
+	return &jiradata.Attachment{
+		ID:       jiradata.IntOrString(id),
+		Filename: filename,
+		MimeType: "text/plain",
+		Content:  "https://jira.example.com/secure/attachment/" + filename,
+		Author:   &jiradata.User{DisplayName: "Marton Elek"},
+	}
+}
+
+func TestAttachmentStoreDedupe(t *testing.T) {
+	store, cleanup := testAttachmentStore(t)
+	defer cleanup()
+	var downloads []string
+	client := fakeClient(func(req *http.Request) (int, string) {
+		downloads = append(downloads, req.URL.Path)
+		return http.StatusOK, "patch content"
+	})
+	created := time.Date(2018, 3, 1, 10, 0, 0, 0, time.UTC)
+	ctx := context.Background()
+
+	first, err := store.Save(ctx, client, "HDDS-1", testAttachment(1, "HDDS-1.001.patch"), created)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len("patch content")), first.Size)
+	assert.Equal(t, "Marton Elek", first.Author)
+	content, err := ioutil.ReadFile(first.Path)
+	assert.Nil(t, err)
+	assert.Equal(t, "patch content", string(content))
+
+	// the same attachment id is not downloaded again
+	again, err := store.Save(ctx, client, "HDDS-1", testAttachment(1, "HDDS-1.001.patch"), created)
+	assert.Nil(t, err)
+	assert.Equal(t, first, again)
+	assert.Equal(t, 1, len(downloads))
+
+	// the same content of another attachment is stored once
+	copied, err := store.Save(ctx, client, "HDDS-2", testAttachment(2, "HDDS-2.001.patch"), created)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(downloads))
+	assert.Equal(t, first.Path, copied.Path)
+	assert.Equal(t, "HDDS-2", copied.IssueKey)
+	objects, err := ioutil.ReadDir(path.Dir(first.Path))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(objects))
+}
+
+func TestAttachmentStoreExistingMeta(t *testing.T) {
+	store, cleanup := testAttachmentStore(t)
+	defer cleanup()
+	meta := `{"id": 3, "issueKey": "HDDS-3", "filename": "logs.zip", "size": 42, "sha256": "abcd", "path": "objects/ab/abcd"}`
+	assert.Nil(t, ioutil.WriteFile(store.metaFile(3), []byte(meta), 0644))
+	client := fakeClient(func(req *http.Request) (int, string) {
+		t.Error("unexpected download of " + req.URL.String())
+		return http.StatusOK, ""
+	})
+
+	stored, err := store.Save(context.Background(), client, "HDDS-3", testAttachment(3, "logs.zip"), time.Time{})
+	assert.Nil(t, err)
+	assert.Equal(t, StoredAttachment{ID: 3, IssueKey: "HDDS-3", Filename: "logs.zip", Size: 42, Sha256: "abcd",
+		Path: "objects/ab/abcd"}, stored)
+
+	// a corrupt meta file is reported
+	assert.Nil(t, ioutil.WriteFile(store.metaFile(4), []byte("{"), 0644))
+	_, err = store.Save(context.Background(), client, "HDDS-3", testAttachment(4, "logs.zip"), time.Time{})
+	assert.NotNil(t, err)
+}
+
+func TestAttachmentStoreDownloadError(t *testing.T) {
+	store, cleanup := testAttachmentStore(t)
+	defer cleanup()
+	client := fakeClient(func(req *http.Request) (int, string) {
+		return http.StatusNotFound, "not found"
+	})
+
+	_, err := store.Save(context.Background(), client, "HDDS-1", testAttachment(5, "missing.txt"), time.Time{})
+	assert.Equal(t, exitJiraError, exitCode(err))
+	// neither the metadata nor the temporary file is kept, the next run retries the download
+	_, err = os.Stat(store.metaFile(5))
+	assert.True(t, os.IsNotExist(err))
+	files, err := ioutil.ReadDir(path.Join(store.Dir, "tmp"))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(files))
+}
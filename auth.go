@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync"
+
+	"github.com/elek/jira-retriever/jiradata"
+)
+
+// Supported authentication modes of the jira calls.
+const (
+	// Cloud if username and token are defined, bearer if only token, basic if username, anonymous access otherwise.
+	authAuto = ""
+	authNone = "none"
+	// Basic auth with username and password.
+	authBasic = "basic"
+	// Jira Cloud: basic auth with the email address as username and an API token.
+	authCloud = "cloud"
+	// Jira Data Center personal access token.
+	authBearer = "bearer"
+	// Cookie based session created with the /rest/auth/1/session endpoint.
+	authSession = "session"
+)
+
+// AuthProvider adds the credentials to the jira requests.
+type AuthProvider interface {
+	// Authenticate adds the credentials to the request.
+	Authenticate(ctx context.Context, req *http.Request) error
+	// Invalidate is called if jira is responded with HTTP 401 to the request. It returns true if the credentials are
+	// renewed and the request could be repeated.
+	Invalidate(req *http.Request) bool
+}
+
+// newAuthProvider creates the authentication provider of the jira client based on the authentication mode.
+func newAuthProvider(client *JiraClient) (AuthProvider, error) {
+	switch client.Auth {
+	case authAuto:
+		switch {
+		case client.Token != "" && client.Username != "":
+			return &basicAuth{username: client.Username, password: client.Token}, nil
+		case client.Token != "":
+			return &bearerAuth{token: client.Token}, nil
+		case client.Username != "":
+			return &basicAuth{username: client.Username, password: client.Password}, nil
+		}
+		return &anonymousAuth{}, nil
+	case authNone:
+		return &anonymousAuth{}, nil
+	case authBasic:
+		return &basicAuth{username: client.Username, password: client.Password}, nil
+	case authCloud:
+		if client.Username == "" || client.Token == "" {
+			return nil, errors.New("Jira Cloud authentication requires the email address as username and an API token")
+		}
+		return &basicAuth{username: client.Username, password: client.Token}, nil
+	case authBearer:
+		if client.Token == "" {
+			return nil, errors.New("Bearer authentication requires a personal access token")
+		}
+		return &bearerAuth{token: client.Token}, nil
+	case authSession:
+		return &sessionAuth{client: client, username: client.Username, password: client.Password}, nil
+	default:
+		return nil, errors.New("Unknown authentication mode: " + client.Auth)
+	}
+}
+
+type anonymousAuth struct{}
+
+func (auth *anonymousAuth) Authenticate(ctx context.Context, req *http.Request) error {
+	return nil
+}
+
+func (auth *anonymousAuth) Invalidate(req *http.Request) bool {
+	return false
+}
+
+type basicAuth struct {
+	username string
+	password string
+}
+
+func (auth *basicAuth) Authenticate(ctx context.Context, req *http.Request) error {
+	req.SetBasicAuth(auth.username, auth.password)
+	return nil
+}
+
+func (auth *basicAuth) Invalidate(req *http.Request) bool {
+	return false
+}
+
+type bearerAuth struct {
+	token string
+}
+
+func (auth *bearerAuth) Authenticate(ctx context.Context, req *http.Request) error {
+	req.Header.Set("Authorization", "Bearer "+auth.token)
+	return nil
+}
+
+func (auth *bearerAuth) Invalidate(req *http.Request) bool {
+	return false
+}
+
+// sessionAuth logs in with the session endpoint and sends the session cookie with the requests. The session is
+// created again when it's expired, only once even if multiple workers are rejected with the same session.
+type sessionAuth struct {
+	client   *JiraClient
+	username string
+	password string
+	mutex    sync.Mutex
+	session  *jiradata.SessionInfo
+}
+
+func (auth *sessionAuth) Authenticate(ctx context.Context, req *http.Request) error {
+	auth.mutex.Lock()
+	defer auth.mutex.Unlock()
+	if auth.session == nil {
+		session, err := auth.login(ctx)
+		if err != nil {
+			return err
+		}
+		auth.session = session
+	}
+	req.AddCookie(&http.Cookie{Name: auth.session.Name, Value: auth.session.Value})
+	return nil
+}
+
+func (auth *sessionAuth) Invalidate(req *http.Request) bool {
+	auth.mutex.Lock()
+	defer auth.mutex.Unlock()
+	if auth.session == nil {
+		return true
+	}
+	// the session is already renewed by an other worker if the request is sent with a different one
+	if cookie, err := req.Cookie(auth.session.Name); err == nil && cookie.Value == auth.session.Value {
+		log.Print("Jira session is expired, logging in again")
+		auth.session = nil
+	}
+	return true
+}
+
+func (auth *sessionAuth) login(ctx context.Context) (*jiradata.SessionInfo, error) {
+	sessionUrl := auth.client.Url + "/rest/auth/1/session"
+	body, err := json.Marshal(jiradata.AuthParams{Username: auth.username, Password: auth.password})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", sessionUrl, bytes.NewReader(body))
+	if err != nil {
+		return nil, &RequestError{Method: "POST", Url: sessionUrl, Err: err}
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	response, err := auth.client.client().Do(req)
+	if err != nil {
+		return nil, &RequestError{Method: "POST", Url: sessionUrl, Err: err}
+	}
+	defer response.Body.Close()
+	content, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, &RequestError{Method: "POST", Url: sessionUrl, Err: err}
+	}
+	if response.StatusCode >= 400 {
+		return nil, newHTTPError("POST", sessionUrl, response.StatusCode, content)
+	}
+	var success jiradata.AuthSuccess
+	err = decode(content, &success, "Jira session")
+	if err != nil {
+		return nil, err
+	}
+	if success.Session == nil {
+		return nil, &DecodeError{What: "Jira session", Err: errors.New("session is missing from the response")}
+	}
+	return success.Session, nil
+}
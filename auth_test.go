@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAutoAuthMode(t *testing.T) {
+	cases := []struct {
+		username string
+		password string
+		token    string
+		expected AuthProvider
+	}{
+		{"", "", "", &anonymousAuth{}},
+		{"elek", "secret", "", &basicAuth{username: "elek", password: "secret"}},
+		{"", "", "pat", &bearerAuth{token: "pat"}},
+		{"elek@example.com", "", "api-token", &basicAuth{username: "elek@example.com", password: "api-token"}},
+	}
+	for _, c := range cases {
+		provider, err := newAuthProvider(&JiraClient{Username: c.username, Password: c.password, Token: c.token})
+		assert.Nil(t, err)
+		assert.Equal(t, c.expected, provider)
+	}
+}
+
+func TestSessionIsRenewedOnce(t *testing.T) {
+	var mutex sync.Mutex
+	logins := 0
+	valid := ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if r.URL.Path == "/rest/auth/1/session" {
+			logins++
+			valid = "session-" + strconv.Itoa(logins)
+			w.Write([]byte(`{"session":{"name":"JSESSIONID","value":"` + valid + `"}}`))
+			return
+		}
+		if cookie, err := r.Cookie("JSESSIONID"); err != nil || cookie.Value != valid {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	rate := 0.0
+	config := Config{
+		Jira: map[string]*JiraConfig{"apache": {Url: server.URL, Username: "elek", Auth: authSession, Rate: &rate}},
+		Pipelines: map[string]*PipelineConfig{
+			"hadoop": {JQL: "project = HADOOP"},
+			"ozone":  {JQL: "project = HDDS"},
+		},
+	}
+	clients := make(map[string]*JiraClient)
+	hadoop, err := config.pipelineClient("hadoop", clients)
+	assert.Nil(t, err)
+	ozone, err := config.pipelineClient("ozone", clients)
+	assert.Nil(t, err)
+
+	_, err = hadoop.query(context.Background(), "/rest/api/2/myself")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, logins)
+
+	// the session is expired, all the workers of both pipelines are rejected with it
+	mutex.Lock()
+	valid = ""
+	mutex.Unlock()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		client := hadoop
+		if i%2 == 1 {
+			client = ozone
+		}
+		wg.Add(1)
+		go func(client JiraClient) {
+			defer wg.Done()
+			_, err := client.query(context.Background(), "/rest/api/2/myself")
+			assert.Nil(t, err)
+		}(client)
+	}
+	wg.Wait()
+	assert.Equal(t, 2, logins)
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+)
+
+// Message layouts of the slack adapter.
+const (
+	// Legacy message attachments.
+	layoutAttachments = "attachments"
+	// Block Kit blocks.
+	layoutBlocks = "blocks"
+)
+
+// Limits of the Block Kit messages.
+const (
+	maxBlocks      = 50
+	maxSectionText = 3000
+	maxFieldText   = 2000
+)
+
+// slackApiUrl is the base url of the slack web api (overridden by the tests).
+var slackApiUrl = "https://slack.com/api/"
+
+// slackHttpClient is used for the direct slack web api calls. A hanging call is stopped after the same timeout as the
+// jira calls.
+var slackHttpClient = &http.Client{Timeout: defaultTimeout}
+
+// TextObject is a text of a Block Kit block.
+type TextObject struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// Block is a Block Kit layout block. Only the section, context and divider blocks are used.
+type Block struct {
+	Type     string        `json:"type"`
+	Text     *TextObject   `json:"text,omitempty"`
+	Fields   []*TextObject `json:"fields,omitempty"`
+	Elements []*TextObject `json:"elements,omitempty"`
+}
+
+func markdown(text string) *TextObject {
+	return &TextObject{Type: "mrkdwn", Text: text}
+}
+
+// issueBlocks returns the Block Kit messages of the changes of an issue: the header section and the status, assignee
+// and priority fields, then every event separated by dividers. The blocks are split to multiple messages according to
+// the size limits of slack.
+func (slackAdapter *SlackAdapter) issueBlocks(header string, events []*Event) ([][]Block, int, error) {
+	first := []Block{{Type: "section", Text: markdown(truncate(header, maxSectionText))}}
+	if fields := issueFields(events); len(fields) > 0 {
+		first = append(first, Block{Type: "section", Fields: fields})
+	}
+	groups := [][]Block{first}
+	rendered := 0
+	for _, event := range events {
+		title, err := slackAdapter.Renderer.Title(event)
+		if err != nil {
+			return nil, 0, err
+		}
+		text, err := slackAdapter.Renderer.Event(event)
+		if err != nil {
+			return nil, 0, err
+		}
+		if title == "" && text == "" {
+			continue
+		}
+		rendered++
+		group := []Block{{Type: "divider"}}
+		body := text
+		if title != "" {
+			body = "*" + title + "*\n" + text
+		}
+		for _, part := range splitText(body, maxSectionText) {
+			group = append(group, Block{Type: "section", Text: markdown(part)})
+		}
+		group = append(group, Block{Type: "context", Elements: []*TextObject{markdown(eventContext(event))}})
+		groups = append(groups, group)
+	}
+	return packBlocks(groups, maxBlocks), rendered, nil
+}
+
+// issueFields returns the status, assignee and priority of the latest known state of the issue.
+func issueFields(events []*Event) []*TextObject {
+	var fields []*TextObject
+	for idx := len(events) - 1; idx >= 0; idx-- {
+		issue := events[idx].Issue
+		if issue == nil {
+			continue
+		}
+		values := []struct {
+			title string
+			field string
+			key   string
+		}{
+			{"Status", "status", "name"},
+			{"Assignee", "assignee", "displayName"},
+			{"Priority", "priority", "name"},
+		}
+		for _, value := range values {
+			text := ""
+			if field, ok := issue.Fields[value.field].(map[string]interface{}); ok {
+				text, _ = field[value.key].(string)
+			} else if value.field == "assignee" {
+				text = "Unassigned"
+			}
+			if text != "" {
+				fields = append(fields, markdown(truncate("*"+value.title+"*\n"+text, maxFieldText)))
+			}
+		}
+		return fields
+	}
+	return fields
+}
+
+// eventContext returns the author and the time of the event. The time is shown in the time zone of the reader.
+func eventContext(event *Event) string {
+	when := fmt.Sprintf("<!date^%d^{date_short_pretty} {time}|%s>", event.Timestamp.Unix(),
+		event.Timestamp.Format("2006-01-02 15:04"))
+	if event.Actor.DisplayName == "" {
+		return when
+	}
+	return event.Actor.DisplayName + " | " + when
+}
+
+// packBlocks groups the blocks to messages with at most max blocks. The blocks of a group are kept in the same message
+// if it's possible.
+func packBlocks(groups [][]Block, max int) [][]Block {
+	var messages [][]Block
+	var current []Block
+	for _, group := range groups {
+		if len(current)+len(group) > max && len(current) > 0 {
+			messages = append(messages, current)
+			current = nil
+		}
+		for len(group) > max {
+			messages = append(messages, group[:max])
+			group = group[max:]
+		}
+		current = append(current, group...)
+	}
+	if len(current) > 0 {
+		messages = append(messages, current)
+	}
+	return messages
+}
+
+// splitText splits the text to parts with at most max characters, at line breaks if it's possible.
+func splitText(text string, max int) []string {
+	var parts []string
+	runes := []rune(text)
+	for len(runes) > max {
+		cut := max
+		for idx := max - 1; idx > max/2; idx-- {
+			if runes[idx] == '\n' {
+				cut = idx
+				break
+			}
+		}
+		parts = append(parts, string(runes[:cut]))
+		runes = runes[cut:]
+		if len(runes) > 0 && runes[0] == '\n' {
+			runes = runes[1:]
+		}
+	}
+	return append(parts, string(runes))
+}
+
+func truncate(text string, max int) string {
+	runes := []rune(text)
+	if len(runes) <= max {
+		return text
+	}
+	return string(runes[:max-1]) + "…"
+}
+
+// PostBlocks sends a Block Kit message to the channel (or to the thread if it's not empty) and returns its timestamp.
+// The text is the fallback of the notifications. The old slack library has no Block Kit support, so the web api is
+// called directly.
+func (slackAdapter *SlackAdapter) PostBlocks(ctx context.Context, channel string, text string, blocks []Block,
+	thread string, broadcast bool) (string, error) {
+	message := map[string]interface{}{
+		"channel":  channel,
+		"text":     text,
+		"blocks":   blocks,
+		"username": "Jira changes bot",
+	}
+	if thread != "" {
+		message["thread_ts"] = thread
+		message["reply_broadcast"] = broadcast
+	}
+	content, err := json.Marshal(message)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest("POST", slackApiUrl+"chat.postMessage", bytes.NewReader(content))
+	if err != nil {
+		return "", err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set("Authorization", "Bearer "+slackAdapter.Token)
+	resp, err := slackHttpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	var response struct {
+		Ok    bool   `json:"ok"`
+		Error string `json:"error"`
+		Ts    string `json:"ts"`
+	}
+	err = json.Unmarshal(body, &response)
+	if err != nil {
+		return "", fmt.Errorf("Slack API is responded with HTTP %d: %s", resp.StatusCode, err.Error())
+	}
+	if !response.Ok {
+		return "", errors.New("Slack API is responded with error: " + response.Error)
+	}
+	return response.Ts, nil
+}
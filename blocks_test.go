@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elek/jira-retriever/jiradata"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSlackBlocksLayout(t *testing.T) {
+	var messages []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/chat.postMessage", r.URL.Path)
+		assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+		body, _ := ioutil.ReadAll(r.Body)
+		var message map[string]interface{}
+		assert.Nil(t, json.Unmarshal(body, &message))
+		messages = append(messages, message)
+		w.Write([]byte(`{"ok": true, "ts": "1519898400.000100"}`))
+	}))
+	defer server.Close()
+	defer func(url string) { slackApiUrl = url }(slackApiUrl)
+	slackApiUrl = server.URL + "/"
+
+	issue := &jiradata.Issue{Key: "HDDS-1", Fields: map[string]interface{}{
+		"status":   map[string]interface{}{"name": "Resolved"},
+		"priority": map[string]interface{}{"name": "Major"},
+	}}
+	created := time.Date(2018, 3, 1, 10, 0, 0, 0, time.UTC)
+	adapter := NewSlackAdapter("ozone", "token")
+	adapter.Layout = layoutBlocks
+	for idx := 0; idx < 20; idx++ {
+		event := commentEvent(&CommentItem{
+			BaseIssueInfo: BaseIssueInfo{IssueKey: "HDDS-1", IssueSummary: "Test issue", Created: created},
+			Comment: jiradata.Comment{ID: "1", Body: strings.Repeat("x", 4000),
+				Author: &jiradata.User{DisplayName: "Marton Elek"}},
+		}, EventCommentAdded)
+		event.Issue = issue
+		assert.Nil(t, adapter.saveEvent(context.Background(), event, "selector"))
+	}
+	assert.Nil(t, adapter.Finish(context.Background()))
+
+	// 2 header blocks + 20 * (divider, 2 sections, context)
+	assert.Equal(t, 2, len(messages))
+	first := messages[0]["blocks"].([]interface{})
+	assert.Equal(t, 50, len(first))
+	assert.Equal(t, 32, len(messages[1]["blocks"].([]interface{})))
+	assert.Equal(t, "HDDS-1 *Test issue*", messages[0]["text"])
+	fields := first[1].(map[string]interface{})["fields"].([]interface{})
+	assert.Equal(t, "*Status*\nResolved", fields[0].(map[string]interface{})["text"])
+	assert.Equal(t, "*Assignee*\nUnassigned", fields[1].(map[string]interface{})["text"])
+	assert.Equal(t, "divider", first[2].(map[string]interface{})["type"])
+	contextBlock := first[5].(map[string]interface{})["elements"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "Marton Elek | <!date^1519898400^{date_short_pretty} {time}|2018-03-01 10:00>", contextBlock["text"])
+}
+
+func TestSplitText(t *testing.T) {
+	assert.Equal(t, []string{"short"}, splitText("short", 10))
+	assert.Equal(t, []string{"first line", "second"}, splitText("first line\nsecond", 12))
+	assert.Equal(t, []string{"aaaaaaaaaa", "aa"}, splitText("aaaaaaaaaaaa", 10))
+}
+
+func TestPostBlocksTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+	defer func(url string, client *http.Client) { slackApiUrl, slackHttpClient = url, client }(slackApiUrl, slackHttpClient)
+	slackApiUrl = server.URL + "/"
+	slackHttpClient = &http.Client{Timeout: 50 * time.Millisecond}
+
+	adapter := NewSlackAdapter("ozone", "token")
+	_, err := adapter.PostBlocks(context.Background(), "ozone", "text", nil, "", false)
+	assert.NotNil(t, err)
+}
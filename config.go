@@ -0,0 +1,334 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Config is the content of the config file: the jira connections and the named pipelines which use them.
+//
+// Example:
+//
+//  jira:
+//    apache:
+//      url: https://issues.apache.org/jira
+//      username: elek
+//      password:
+//        file: /run/secrets/jira-password
+//  pipelines:
+//    hadoop-slack:
+//      jira: apache
+//      jql: project = HADOOP
+//      since: last
+//      schedule: 15m
+//      sinks:
+//        - type: slack
+//          channel: hadoop
+//          token:
+//            env: SLACK_TOKEN
+type Config struct {
+	Jira      map[string]*JiraConfig     `yaml:"jira"`
+	Pipelines map[string]*PipelineConfig `yaml:"pipelines"`
+}
+
+// JiraConfig is a jira connection of the config file. The omitted values are the same as the defaults of the command
+// line flags.
+type JiraConfig struct {
+	Url      string        `yaml:"url"`
+	Username string        `yaml:"username"`
+	Password SecretSource  `yaml:"password"`
+	Token    SecretSource  `yaml:"token"`
+	Auth     string        `yaml:"auth"`
+	Rate     *float64      `yaml:"rate"`
+	Burst    int           `yaml:"burst"`
+	Retries  int           `yaml:"retries"`
+	Timeout  time.Duration `yaml:"timeout"`
+	Workers  int           `yaml:"workers"`
+	PageSize int           `yaml:"pagesize"`
+	// Name of the instance in the messages (eg. if the pipelines of multiple instances use the same channel)
+	DisplayName string `yaml:"displayname"`
+	// Base url of the issue links (default: the base url of the issues returned by jira)
+	Browse string `yaml:"browse"`
+	// Short icon of the instance in the message headers, eg. a slack emoji
+	Icon string `yaml:"icon"`
+}
+
+// PipelineConfig is a named pipeline: the changes of the jira query are sent to all the sinks.
+type PipelineConfig struct {
+	Jira        string       `yaml:"jira"`
+	JQL         string       `yaml:"jql"`
+	Since       string       `yaml:"since"`
+	Attachments string       `yaml:"attachments"`
+	Schedule    string       `yaml:"schedule"`
+	Sinks       []SinkConfig `yaml:"sinks"`
+}
+
+// SinkConfig defines one adapter of a pipeline. The used fields depend on the type (console, slack, db).
+type SinkConfig struct {
+	// Name of the sink in the logs and the status (default: the type)
+	Name string `yaml:"name"`
+	Type string `yaml:"type"`
+	// Filter of the events sent to the sink (default: all the events)
+	Filter *FilterConfig `yaml:"filter"`
+	// Templates of the console and slack output by template name (see render.go)
+	Templates map[string]string `yaml:"templates"`
+
+	// slack
+	Channel string       `yaml:"channel"`
+	Token   SecretSource `yaml:"token"`
+	// One thread per issue, rotated after the threadage (see ThreadStore)
+	Threads   bool          `yaml:"threads"`
+	ThreadAge time.Duration `yaml:"threadage"`
+	// Event types which are also sent to the channel from the threads
+	Broadcast StringList `yaml:"broadcast"`
+	// Layout of the messages: attachments (default) or blocks
+	Layout string `yaml:"layout"`
+	// Routes of the events to other channels (the channel is the default channel)
+	Routes []SlackRoute `yaml:"routes"`
+
+	// db
+	Host     string       `yaml:"host"`
+	Username string       `yaml:"username"`
+	Password SecretSource `yaml:"password"`
+	Database string       `yaml:"database"`
+}
+
+// UnmarshalYAML reads a secret from a plain string value or from a map with one of the value, file, command and env
+// keys.
+func (source *SecretSource) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var value string
+	if err := unmarshal(&value); err == nil {
+		source.Value = value
+		return nil
+	}
+	var sources struct {
+		Value   string `yaml:"value"`
+		File    string `yaml:"file"`
+		Command string `yaml:"command"`
+		Env     string `yaml:"env"`
+	}
+	if err := unmarshal(&sources); err != nil {
+		return err
+	}
+	source.Value = sources.Value
+	source.File = sources.File
+	source.Command = sources.Command
+	source.Env = sources.Env
+	return nil
+}
+
+// defaultConfigFile returns the location of the config file: $JIRA_RETRIEVER_CONFIG or ~/.jira-retriever/config.yaml.
+func defaultConfigFile() string {
+	if file := os.Getenv("JIRA_RETRIEVER_CONFIG"); file != "" {
+		return file
+	}
+	return path.Join(os.Getenv("HOME"), ".jira-retriever", "config.yaml")
+}
+
+// LoadConfig reads and validates the config file.
+func LoadConfig(file string) (*Config, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, errors.New("Config file couldn't be read: " + err.Error())
+	}
+	var config Config
+	err = yaml.UnmarshalStrict(content, &config)
+	if err != nil {
+		return nil, errors.New("Config file " + file + " is invalid: " + err.Error())
+	}
+	return &config, config.validate()
+}
+
+func (config *Config) validate() error {
+	if len(config.Pipelines) == 0 {
+		return errors.New("No pipeline is defined in the config file")
+	}
+	for name, jira := range config.Jira {
+		if jira == nil || jira.Url == "" {
+			return errors.New("Url of the jira connection " + name + " is missing")
+		}
+	}
+	for name, pipeline := range config.Pipelines {
+		if pipeline == nil {
+			return errors.New("Pipeline " + name + " is empty")
+		}
+		if _, err := config.jiraOf(name); err != nil {
+			return err
+		}
+		if pipeline.Schedule != "" {
+			if _, err := ParseSchedule(pipeline.Schedule); err != nil {
+				return errors.New("Schedule of the pipeline " + name + " is invalid: " + err.Error())
+			}
+		}
+		if len(pipeline.Sinks) == 0 {
+			return errors.New("No sink is defined for the pipeline " + name)
+		}
+		names := make(map[string]bool)
+		for idx, sink := range pipeline.Sinks {
+			if names[sink.name(idx)] {
+				return errors.New("Sink name " + sink.name(idx) + " of the pipeline " + name + " is not unique")
+			}
+			names[sink.name(idx)] = true
+			if _, err := sink.renderer(); err != nil {
+				return errors.New("Sink " + sink.name(idx) + " of the pipeline " + name + " is invalid: " + err.Error())
+			}
+			switch sink.Type {
+			case "console", "db":
+			case "slack":
+				if sink.Channel == "" && len(sink.Routes) == 0 {
+					return errors.New("Slack channel of the pipeline " + name + " is missing")
+				}
+				for _, route := range sink.Routes {
+					if len(route.Channels) == 0 {
+						return errors.New("Slack route of the pipeline " + name + " has no channel")
+					}
+				}
+				if sink.Layout != "" && sink.Layout != layoutAttachments && sink.Layout != layoutBlocks {
+					return errors.New("Unknown slack layout of the pipeline " + name + ": " + sink.Layout)
+				}
+			default:
+				return errors.New("Unknown sink type of the pipeline " + name + ": " + sink.Type)
+			}
+		}
+	}
+	return nil
+}
+
+// name returns the name of the sink. The sinks without name are named by their type and their index.
+func (sink SinkConfig) name(idx int) string {
+	if sink.Name != "" {
+		return sink.Name
+	}
+	return fmt.Sprintf("%s-%d", sink.Type, idx+1)
+}
+
+// renderer returns the renderer of the built-in templates of the sink type with the templates of the sink.
+func (sink SinkConfig) renderer() (*Renderer, error) {
+	switch sink.Type {
+	case "console":
+		return NewRenderer(consoleTemplates, sink.Templates)
+	case "slack":
+		return NewRenderer(slackTemplates, sink.Templates)
+	}
+	if len(sink.Templates) > 0 {
+		return nil, errors.New("templates are supported only by the console and slack sinks")
+	}
+	return nil, nil
+}
+
+// PipelineNames returns the names of all the pipelines in alphabetical order.
+func (config *Config) PipelineNames() []string {
+	names := make([]string, 0, len(config.Pipelines))
+	for name := range config.Pipelines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// jiraOf returns the name of the jira connection of the pipeline. The connection could be omitted if only one is
+// defined.
+func (config *Config) jiraOf(pipeline string) (string, error) {
+	name := config.Pipelines[pipeline].Jira
+	if name == "" {
+		if len(config.Jira) != 1 {
+			return "", errors.New("Jira connection of the pipeline " + pipeline + " is missing")
+		}
+		for jiraName := range config.Jira {
+			return jiraName, nil
+		}
+	}
+	if _, ok := config.Jira[name]; !ok {
+		return "", errors.New("Unknown jira connection of the pipeline " + pipeline + ": " + name)
+	}
+	return name, nil
+}
+
+// client creates the jira client of the connection with the same credential fallbacks as the command line flags. The
+// environment variables (JIRA_USERNAME, JIRA_PASSWORD, JIRA_TOKEN) are used only if it's the only jira connection
+// (single), otherwise the credentials of one jira would be sent to the other ones.
+func (jiraConfig *JiraConfig) client(name string, single bool) (*JiraClient, error) {
+	var err error
+	client := JiraClient{
+		Url:      jiraConfig.Url,
+		Username: jiraConfig.Username,
+		Auth:     jiraConfig.Auth,
+		Burst:    jiraConfig.Burst,
+		Workers:  jiraConfig.Workers,
+		PageSize: jiraConfig.PageSize,
+		Timeout:  jiraConfig.Timeout,
+		Retry:    defaultRetryPolicy,
+		Instance: JiraInstance{
+			Name:        name,
+			DisplayName: jiraConfig.DisplayName,
+			BrowseUrl:   jiraConfig.Browse,
+			Icon:        jiraConfig.Icon,
+		},
+	}
+	client.RateLimit = 1
+	if jiraConfig.Rate != nil {
+		client.RateLimit = *jiraConfig.Rate
+	}
+	if client.Burst <= 0 {
+		client.Burst = 5
+	}
+	if jiraConfig.Retries > 0 {
+		client.Retry.MaxAttempts = jiraConfig.Retries
+	}
+
+	password := jiraConfig.Password
+	password.Name = name + " jira password"
+	if password.Env == "" && single {
+		password.Env = "JIRA_PASSWORD"
+	}
+	client.Password, err = password.Resolve()
+	if err != nil {
+		return nil, err
+	}
+	token := jiraConfig.Token
+	token.Name = name + " jira token"
+	if token.Env == "" && single {
+		token.Env = "JIRA_TOKEN"
+	}
+	client.Token, err = token.Resolve()
+	if err != nil {
+		return nil, err
+	}
+	client.defaultCredentials(single)
+	return &client, nil
+}
+
+// pipelineClient returns a jira client for the pipeline. The secrets of a jira connection are resolved only once and
+// the pipelines of the same connection share the http client and the authentication (eg. the jira session).
+func (config *Config) pipelineClient(pipeline string, clients map[string]*JiraClient) (JiraClient, error) {
+	jiraName, err := config.jiraOf(pipeline)
+	if err != nil {
+		return JiraClient{}, err
+	}
+	shared, ok := clients[jiraName]
+	if !ok {
+		shared, err = config.Jira[jiraName].client(jiraName, len(config.Jira) == 1)
+		if err != nil {
+			return JiraClient{}, err
+		}
+		// created before the copies, otherwise every pipeline would create its own
+		shared.client()
+		_, err = shared.authentication()
+		if err != nil {
+			return JiraClient{}, err
+		}
+		clients[jiraName] = shared
+	}
+	client := *shared
+	client.JQL = config.Pipelines[pipeline].JQL
+	client.Since = config.Pipelines[pipeline].Since
+	client.AttachmentDir = config.Pipelines[pipeline].Attachments
+	return client, nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadConfig(t *testing.T) {
+	file, err := ioutil.TempFile("", "jira-retriever-config")
+	assert.Nil(t, err)
+	defer os.Remove(file.Name())
+	file.WriteString(`
+jira:
+  apache:
+    url: https://issues.apache.org/jira
+    username: elek
+    password:
+      file: /run/secrets/jira
+    token: secret
+pipelines:
+  hadoop:
+    jql: project = HADOOP
+    sinks:
+      - type: console
+      - type: slack
+        channel: hadoop
+`)
+	file.Close()
+
+	config, err := LoadConfig(file.Name())
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"hadoop"}, config.PipelineNames())
+	assert.Equal(t, "/run/secrets/jira", config.Jira["apache"].Password.File)
+	assert.Equal(t, "secret", config.Jira["apache"].Token.Value)
+	jiraName, err := config.jiraOf("hadoop")
+	assert.Nil(t, err)
+	assert.Equal(t, "apache", jiraName)
+
+	config.Pipelines["hadoop"].Sinks[1].Channel = ""
+	assert.NotNil(t, config.validate())
+}
+
+func TestJiraEnvCredentials(t *testing.T) {
+	os.Setenv("JIRA_TOKEN", "apache-token")
+	defer os.Unsetenv("JIRA_TOKEN")
+	os.Setenv("NETRC", "/nonexistent")
+	defer os.Unsetenv("NETRC")
+	config := Config{Jira: map[string]*JiraConfig{
+		"apache": {Url: "https://issues.apache.org/jira"},
+	}}
+	config.Pipelines = map[string]*PipelineConfig{"hadoop": {JQL: "project = HADOOP"}}
+
+	client, err := config.pipelineClient("hadoop", make(map[string]*JiraClient))
+	assert.Nil(t, err)
+	assert.Equal(t, "apache-token", client.Token)
+
+	// the token of one jira is not sent to the other one
+	config.Jira["cloudera"] = &JiraConfig{Url: "https://issues.cloudera.org"}
+	config.Pipelines["hadoop"].Jira = "cloudera"
+	client, err = config.pipelineClient("hadoop", make(map[string]*JiraClient))
+	assert.Nil(t, err)
+	assert.Equal(t, "", client.Token)
+}
+
+func TestPipelineLockKey(t *testing.T) {
+	rate := 0.0
+	config := Config{
+		Jira: map[string]*JiraConfig{
+			"apache":   {Url: "https://issues.apache.org/jira", Rate: &rate},
+			"cloudera": {Url: "https://issues.cloudera.org", Rate: &rate},
+		},
+		Pipelines: map[string]*PipelineConfig{
+			"hadoop":          {Jira: "apache", JQL: "project = HADOOP"},
+			"hadoop-again":    {Jira: "apache", JQL: "project = HADOOP"},
+			"cloudera-hadoop": {Jira: "cloudera", JQL: "project = HADOOP"},
+		},
+	}
+	clients := make(map[string]*JiraClient)
+	keys := make(map[string]string)
+	selectors := make(map[string]string)
+	for name := range config.Pipelines {
+		runner, err := NewPipelineRunner(&config, name, clients)
+		assert.Nil(t, err)
+		keys[name] = runner.lockKey()
+		selectors[name] = runner.Selector
+	}
+	assert.NotEqual(t, keys["hadoop"], keys["cloudera-hadoop"])
+	// every pipeline has its own state, even with the same query of the same jira connection
+	assert.NotEqual(t, selectors["hadoop"], selectors["hadoop-again"])
+	assert.NotEqual(t, selectors["hadoop"], selectors["cloudera-hadoop"])
+	runner, err := NewPipelineRunner(&config, "hadoop", clients)
+	assert.Nil(t, err)
+	assert.Equal(t, selectors["hadoop"], runner.Selector)
+}
@@ -1,17 +1,19 @@
 package main
 
 import (
+	"context"
 	"time"
 	"github.com/spf13/cobra"
-	"fmt"
-	"strings"
 	"sort"
 )
 
 type ConsoleAdapter struct {
-	Changes   []WithBaseIssueInformation
+	Events    []*Event
+	Renderer  *Renderer
 	selector  string
 	fileState FileState
+	// Sink is the name of the sink in a pipeline, every sink has its own state file.
+	Sink string
 }
 
 func init() {
@@ -19,13 +21,17 @@ func init() {
 	var consoleCmd = &cobra.Command{
 		Use:   "console",
 		Short: "Print out the latest changes to the console.",
-		Run: func(cmd *cobra.Command, args []string) {
+		RunE: func(cmd *cobra.Command, args []string) error {
 
-			adapter := ConsoleAdapter{}
-			adapter.Changes = make([]WithBaseIssueInformation, 0)
+			adapter := NewConsoleAdapter()
 
-			config := FromFlags(cmd)
-			process(&config, &adapter)
+			config, err := FromFlags(cmd)
+			if err != nil {
+				return err
+			}
+			ctx, cancel := signalContext()
+			defer cancel()
+			return process(ctx, &config, adapter)
 
 		},
 	}
@@ -33,91 +39,56 @@ func init() {
 	rootCmd.AddCommand(consoleCmd)
 }
 
-func (consoleAdapter *ConsoleAdapter) saveIssue(issue JiraItem, selector string) error {
-	if issue.Issue.Fields["created"] == issue.Issue.Fields["updated"] {
-		consoleAdapter.Changes = append(consoleAdapter.Changes, &issue)
-	}
-	return nil
-}
-
-func (consoleAdapter *ConsoleAdapter) saveChange(item ChangeItem, selector string) error {
-	consoleAdapter.Changes = append(consoleAdapter.Changes, &item)
-	return nil
+func NewConsoleAdapter() *ConsoleAdapter {
+	return &ConsoleAdapter{Events: make([]*Event, 0), Renderer: defaultConsoleRenderer}
 }
 
-func (consoleAdapter *ConsoleAdapter) saveComment(comment CommentItem, selector string) error {
-	consoleAdapter.Changes = append(consoleAdapter.Changes, &comment)
+// saveEvent collects the events to print them at the end. The snapshots of the updated issues are not shown.
+func (consoleAdapter *ConsoleAdapter) saveEvent(ctx context.Context, event *Event, selector string) error {
+	if event.Type != EventIssueUpdated {
+		consoleAdapter.Events = append(consoleAdapter.Events, event)
+	}
 	return nil
 }
 
-func (consoleAdapter *ConsoleAdapter) getLastUpdated(selector string) (time.Time, error) {
-	state := CreateFileState(selector)
+func (consoleAdapter *ConsoleAdapter) getLastUpdated(ctx context.Context, selector string) (time.Time, error) {
+	state := CreateSinkFileState(selector, consoleAdapter.Sink)
 	return state.read()
 }
-func (consoleAdapter *ConsoleAdapter) saveLastUpdated(lastUpdated time.Time, selector string) error {
-	state := CreateFileState(selector)
+func (consoleAdapter *ConsoleAdapter) saveLastUpdated(ctx context.Context, lastUpdated time.Time, selector string) error {
+	state := CreateSinkFileState(selector, consoleAdapter.Sink)
 	return state.write(lastUpdated)
 }
 
-func (consoleAdapter *ConsoleAdapter) Commit() error {
+func (consoleAdapter *ConsoleAdapter) Commit(ctx context.Context) error {
 	return nil
 }
-func (consoleAdapter *ConsoleAdapter) Begin() error {
+func (consoleAdapter *ConsoleAdapter) Begin(ctx context.Context) error {
 	return nil
 }
-func (consoleAdapter *ConsoleAdapter) Finish() error {
-	sort.Slice(consoleAdapter.Changes, func(a int, b int) bool {
-		return consoleAdapter.Changes[a].GetCreated().Before(consoleAdapter.Changes[b].GetCreated())
+func (consoleAdapter *ConsoleAdapter) Rollback(ctx context.Context) error {
+	return nil
+}
+func (consoleAdapter *ConsoleAdapter) Finish(ctx context.Context) error {
+	sort.Slice(consoleAdapter.Events, func(a int, b int) bool {
+		return consoleAdapter.Events[a].Timestamp.Before(consoleAdapter.Events[b].Timestamp)
 	})
 
 	prevKey := ""
-	for _, genericItem := range consoleAdapter.Changes {
-		if prevKey != genericItem.GetIssueKey() {
-			println()
-			println()
-			println(fmt.Sprintf("[%s] %s", genericItem.GetIssueKey(), genericItem.GetIssueSummary()))
-			println()
-		}
-		created := genericItem.GetCreated().Format("2006-01-02 15:04")
-		switch item := genericItem.(type) {
-		case *ChangeItem:
-			from := ""
-
-			if item.FromString != "" {
-				from = fmt.Sprintf("%s --> ", item.FromString)
-			}
-			println(fmt.Sprintf("   %s -- %s: %s%s (%s)",
-				created,
-				item.Field,
-				from,
-				item.ToString,
-				item.AuthorName))
-			prevKey = item.IssueKey
-			println()
-		case *JiraItem:
-			creator := item.Issue.Fields["creator"].(map[string]interface{})
-			println(fmt.Sprintf("   %s -- CREATED by %s",
-				created,
-				creator["displayName"].(string)))
-			println()
-			println(item.Issue.Fields["description"].(string))
-			println()
-		case *CommentItem:
-			println(fmt.Sprintf("   %s -- Comment (%s)",
-				created,
-				item.Comment.Author.DisplayName))
-			println()
-			if (item.Comment.Author.DisplayName != "genericqa") {
-				comment := item.Comment.Body
-				comment = strings.Replace(comment, "\n", "\n    ", -1)
-				comment = strings.Replace(comment, "\n", "\n\n", -1)
-				comment = "    " + comment
-				println(comment)
-				println()
+	for _, event := range consoleAdapter.Events {
+		if prevKey != event.IssueKey {
+			header, err := consoleAdapter.Renderer.Header(event)
+			if err != nil {
+				return err
 			}
-
+			print(header)
 		}
-
+		prevKey = event.IssueKey
+		text, err := consoleAdapter.Renderer.Event(event)
+		if err != nil {
+			return err
+		}
+		print(text)
 	}
 
 	return nil
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// SecretSource defines the possible sources of a secret (password, token). The first defined source is used in the
+// following order:
+//
+//  1. Value: given directly as a flag (visible in ps and the shell history, not recommended)
+//  2. File: content of a file, eg. a mounted Kubernetes secret (trailing whitespaces are removed)
+//  3. Command: standard output of a credential helper command executed with sh -c
+//  4. Env: value of an environment variable
+//
+// The jira credentials are also read from ~/.netrc as a last resort (see netrcCredentials).
+type SecretSource struct {
+	Name    string
+	Value   string
+	File    string
+	Command string
+	Env     string
+}
+
+// Resolve returns the secret from the first defined source or empty string if none of them is defined.
+func (source SecretSource) Resolve() (string, error) {
+	if source.Value != "" {
+		return source.Value, nil
+	}
+	if source.File != "" {
+		content, err := ioutil.ReadFile(source.File)
+		if err != nil {
+			return "", errors.New("Secret file of " + source.Name + " couldn't be read: " + err.Error())
+		}
+		return strings.TrimRight(string(content), " \t\r\n"), nil
+	}
+	if source.Command != "" {
+		var stderr bytes.Buffer
+		command := exec.Command("sh", "-c", source.Command)
+		command.Stderr = &stderr
+		output, err := command.Output()
+		if err != nil {
+			return "", errors.New("Credential helper of " + source.Name + " is failed: " + err.Error() + " " +
+				strings.TrimSpace(stderr.String()))
+		}
+		return strings.TrimRight(string(output), " \t\r\n"), nil
+	}
+	if source.Env != "" {
+		return os.Getenv(source.Env), nil
+	}
+	return "", nil
+}
+
+// addSecretFlags registers the -file and -command variants of a secret flag.
+func addSecretFlags(flags *pflag.FlagSet, name string, description string) {
+	flags.String(name+"-file", "", "File to read the "+description+" from (eg. mounted secret)")
+	flags.String(name+"-command", "", "Credential helper command which prints the "+description)
+}
+
+// resolveSecretFlag resolves the secret defined by the flag (and its -file and -command variants) or by the
+// environment variable.
+func resolveSecretFlag(cmd *cobra.Command, name string, env string) (string, error) {
+	return SecretSource{
+		Name:    name,
+		Value:   cmd.Flag(name).Value.String(),
+		File:    cmd.Flag(name + "-file").Value.String(),
+		Command: cmd.Flag(name + "-command").Value.String(),
+		Env:     env,
+	}.Resolve()
+}
+
+// netrcCredentials returns the login and password of the jira host from the netrc file ($NETRC or ~/.netrc). The
+// default entry is used if there is no entry for the host.
+func netrcCredentials(jiraUrl string) (string, string) {
+	parsed, err := url.Parse(jiraUrl)
+	if err != nil {
+		return "", ""
+	}
+	netrcFile := os.Getenv("NETRC")
+	if netrcFile == "" {
+		netrcFile = path.Join(os.Getenv("HOME"), ".netrc")
+	}
+	content, err := ioutil.ReadFile(netrcFile)
+	if err != nil {
+		return "", ""
+	}
+
+	host := parsed.Host
+	if strings.Contains(host, ":") {
+		host = host[:strings.LastIndex(host, ":")]
+	}
+	return parseNetrc(content, host)
+}
+
+// parseNetrc returns the login and password of the host (or of the default entry) from the netrc content. The macro
+// definitions (macdef, up to the next empty line) are skipped.
+func parseNetrc(content []byte, host string) (string, string) {
+	var login, password, defaultLogin, defaultPassword string
+	var current string
+	var tokens []string
+	inMacro := false
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if inMacro {
+			inMacro = strings.TrimSpace(line) != ""
+			continue
+		}
+		tokens = append(tokens, strings.Fields(line)...)
+		for len(tokens) > 0 {
+			// the value of the token could be on the next line
+			if len(tokens) == 1 && tokens[0] != "default" {
+				break
+			}
+			token := tokens[0]
+			switch token {
+			case "machine":
+				current = tokens[1]
+			case "default":
+				current = "default"
+				tokens = tokens[1:]
+				continue
+			case "login":
+				if current == host {
+					login = tokens[1]
+				} else if current == "default" {
+					defaultLogin = tokens[1]
+				}
+			case "password":
+				if current == host {
+					password = tokens[1]
+				} else if current == "default" {
+					defaultPassword = tokens[1]
+				}
+			case "account":
+			case "macdef":
+				// the rest of the line and the following lines are the macro
+				inMacro = true
+				tokens = nil
+				continue
+			default:
+				// unknown token
+				tokens = tokens[1:]
+				continue
+			}
+			tokens = tokens[2:]
+		}
+	}
+	if login == "" && password == "" {
+		return defaultLogin, defaultPassword
+	}
+	return login, password
+}
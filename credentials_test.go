@@ -0,0 +1,113 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseNetrc(t *testing.T) {
+	cases := []struct {
+		name     string
+		content  string
+		login    string
+		password string
+	}{
+		{
+			name:     "one token per line",
+			content:  "machine issues.apache.org\nlogin elek\npassword secret\n",
+			login:    "elek",
+			password: "secret",
+		},
+		{
+			name:     "multiple tokens per line",
+			content:  "machine github.com login octocat password other\nmachine issues.apache.org login elek password secret",
+			login:    "elek",
+			password: "secret",
+		},
+		{
+			name:     "default entry",
+			content:  "machine github.com login octocat password other\ndefault login anonymous password guest\n",
+			login:    "anonymous",
+			password: "guest",
+		},
+		{
+			name:     "host before the default entry",
+			content:  "machine issues.apache.org login elek password secret\ndefault login anonymous password guest\n",
+			login:    "elek",
+			password: "secret",
+		},
+		{
+			name: "macro definition",
+			content: "machine issues.apache.org login elek account hadoop\nmacdef init\nlogin fake\npassword fake\n\n" +
+				"password secret\n",
+			login:    "elek",
+			password: "secret",
+		},
+		{
+			name:    "missing host",
+			content: "machine github.com login octocat password other\n",
+		},
+	}
+	for _, c := range cases {
+		login, password := parseNetrc([]byte(c.content), "issues.apache.org")
+		assert.Equal(t, c.login, login, c.name)
+		assert.Equal(t, c.password, password, c.name)
+	}
+}
+
+func TestSecretSourcePrecedence(t *testing.T) {
+	file, err := ioutil.TempFile("", "jira-retriever-secret")
+	assert.Nil(t, err)
+	defer os.Remove(file.Name())
+	file.WriteString("from-file\n")
+	file.Close()
+	os.Setenv("JIRA_RETRIEVER_TEST_SECRET", "from-env")
+	defer os.Unsetenv("JIRA_RETRIEVER_TEST_SECRET")
+
+	cases := []struct {
+		source   SecretSource
+		expected string
+	}{
+		{SecretSource{Value: "from-value", File: file.Name(), Command: "echo from-command",
+			Env: "JIRA_RETRIEVER_TEST_SECRET"}, "from-value"},
+		{SecretSource{File: file.Name(), Command: "echo from-command", Env: "JIRA_RETRIEVER_TEST_SECRET"}, "from-file"},
+		{SecretSource{Command: "echo from-command", Env: "JIRA_RETRIEVER_TEST_SECRET"}, "from-command"},
+		{SecretSource{Env: "JIRA_RETRIEVER_TEST_SECRET"}, "from-env"},
+		{SecretSource{}, ""},
+	}
+	for _, c := range cases {
+		secret, err := c.source.Resolve()
+		assert.Nil(t, err)
+		assert.Equal(t, c.expected, secret)
+	}
+
+	_, err = SecretSource{Name: "jira password", File: file.Name() + ".missing"}.Resolve()
+	assert.NotNil(t, err)
+}
+
+func TestNetrcIsTheLastResort(t *testing.T) {
+	file, err := ioutil.TempFile("", "jira-retriever-netrc")
+	assert.Nil(t, err)
+	defer os.Remove(file.Name())
+	file.WriteString("machine issues.apache.org login elek password from-netrc\n")
+	file.Close()
+	os.Setenv("NETRC", file.Name())
+	defer os.Unsetenv("NETRC")
+
+	client := JiraClient{Url: "https://issues.apache.org/jira"}
+	client.defaultCredentials(false)
+	assert.Equal(t, "elek", client.Username)
+	assert.Equal(t, "from-netrc", client.Password)
+
+	client = JiraClient{Url: "https://issues.apache.org/jira", Token: "from-env"}
+	client.defaultCredentials(false)
+	assert.Equal(t, "", client.Password)
+
+	// the entry of an other user is not used
+	client = JiraClient{Url: "https://issues.apache.org/jira", Username: "other"}
+	client.defaultCredentials(false)
+	assert.Equal(t, "", client.Password)
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// maxBackoff is the maximum delay of the next run after repeated failures (unless the schedule itself is longer).
+const maxBackoff = 6 * time.Hour
+
+func init() {
+	var listen string
+	var daemonCmd = &cobra.Command{
+		Use:   "daemon [pipeline...]",
+		Short: "Run the pipelines of the config file according to their schedule",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			config, err := LoadConfig(cmd.Flag("config").Value.String())
+			if err != nil {
+				return err
+			}
+			names := args
+			if len(names) == 0 {
+				for _, name := range config.PipelineNames() {
+					if config.Pipelines[name].Schedule != "" {
+						names = append(names, name)
+					} else {
+						log.Print("Pipeline " + name + " has no schedule, skipping")
+					}
+				}
+			}
+			if len(names) == 0 {
+				return errors.New("No pipeline is scheduled in the config file")
+			}
+
+			ctx, cancel := signalContext()
+			defer cancel()
+			daemon := NewDaemon()
+			clients := make(map[string]*JiraClient)
+			for _, name := range names {
+				pipeline, ok := config.Pipelines[name]
+				if !ok {
+					return errors.New("Unknown pipeline: " + name)
+				}
+				if pipeline.Schedule == "" {
+					return errors.New("Pipeline " + name + " has no schedule")
+				}
+				schedule, err := ParseSchedule(pipeline.Schedule)
+				if err != nil {
+					return err
+				}
+				runner, err := NewPipelineRunner(config, name, clients)
+				if err != nil {
+					return err
+				}
+				defer runner.Close()
+				daemon.Add(runner, pipeline.Schedule, schedule)
+			}
+
+			if listen != "" {
+				server := &http.Server{Addr: listen, Handler: daemon}
+				go func() {
+					err := server.ListenAndServe()
+					if err != nil && err != http.ErrServerClosed {
+						log.Print("Status endpoint is failed: " + err.Error())
+					}
+				}()
+				defer server.Close()
+				log.Print("Status is available at http://" + listen + "/status")
+			}
+			daemon.Run(ctx)
+			return nil
+		},
+	}
+	daemonCmd.Flags().StringVar(&listen, "listen", "localhost:8090", "Address of the HTTP status endpoint "+
+		"(disabled if empty)")
+	rootCmd.AddCommand(daemonCmd)
+}
+
+// PipelineStatus is the schedule and the result of the last run of a pipeline.
+type PipelineStatus struct {
+	Name      string       `json:"name"`
+	Schedule  string       `json:"schedule"`
+	Running   bool         `json:"running"`
+	NextRun   time.Time    `json:"nextRun"`
+	LastStart *time.Time   `json:"lastStart,omitempty"`
+	LastEnd   *time.Time   `json:"lastEnd,omitempty"`
+	LastError string       `json:"lastError,omitempty"`
+	Runs      int          `json:"runs"`
+	Failures  int          `json:"consecutiveFailures"`
+	Sinks     []SinkResult `json:"sinks,omitempty"`
+}
+
+type scheduledPipeline struct {
+	runner   *PipelineRunner
+	schedule Schedule
+	status   *PipelineStatus
+}
+
+// Daemon runs the pipelines according to their schedule. The pipelines with the same query of the same jira connection
+// are never executed at the same time, as they would save the same last updated time.
+type Daemon struct {
+	mutex     sync.Mutex
+	pipelines []*scheduledPipeline
+	running   map[string]string
+}
+
+func NewDaemon() *Daemon {
+	return &Daemon{running: make(map[string]string)}
+}
+
+// Add schedules the pipeline. It should be called before Run.
+func (daemon *Daemon) Add(runner *PipelineRunner, spec string, schedule Schedule) {
+	daemon.pipelines = append(daemon.pipelines, &scheduledPipeline{
+		runner:   runner,
+		schedule: schedule,
+		status:   &PipelineStatus{Name: runner.Name, Schedule: spec},
+	})
+}
+
+// Run executes the pipelines until a graceful stop is requested (or the context is cancelled). The running pipelines
+// are finished before it returns.
+func (daemon *Daemon) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, pipeline := range daemon.pipelines {
+		wg.Add(1)
+		go func(pipeline *scheduledPipeline) {
+			defer wg.Done()
+			daemon.loop(ctx, pipeline)
+		}(pipeline)
+	}
+	wg.Wait()
+}
+
+func (daemon *Daemon) loop(ctx context.Context, pipeline *scheduledPipeline) {
+	next := pipeline.schedule.Next(time.Now())
+	// interval based pipelines are executed at the start
+	if _, ok := pipeline.schedule.(intervalSchedule); ok {
+		next = time.Now()
+	}
+	for {
+		if next.IsZero() {
+			log.Print("Pipeline " + pipeline.runner.Name + " has no more scheduled run")
+			return
+		}
+		daemon.mutex.Lock()
+		pipeline.status.NextRun = next
+		daemon.mutex.Unlock()
+
+		timer := time.NewTimer(next.Sub(time.Now()))
+		select {
+		case <-timer.C:
+		case <-stopChannel(ctx):
+			timer.Stop()
+			return
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+
+		failures := daemon.runOnce(ctx, pipeline)
+		now := time.Now()
+		next = backoff(now, pipeline.schedule.Next(now), failures)
+	}
+}
+
+// runOnce executes the pipeline if no other run with the same selector is running. It returns the number of the
+// consecutive failures.
+func (daemon *Daemon) runOnce(ctx context.Context, pipeline *scheduledPipeline) int {
+	name := pipeline.runner.Name
+	key := pipeline.runner.lockKey()
+	daemon.mutex.Lock()
+	if other, ok := daemon.running[key]; ok {
+		daemon.mutex.Unlock()
+		log.Print("Skipping pipeline " + name + ", the same selector is executed by " + other)
+		return pipeline.status.Failures
+	}
+	daemon.running[key] = name
+	start := time.Now()
+	pipeline.status.Running = true
+	pipeline.status.LastStart = &start
+	daemon.mutex.Unlock()
+
+	log.Print("Running pipeline " + name)
+	// the transaction of a failed run is rolled back when the context of the run is cancelled, so the warm database
+	// connection can be used again
+	runCtx, cancel := context.WithCancel(ctx)
+	err := pipeline.runner.Run(runCtx)
+	cancel()
+
+	daemon.mutex.Lock()
+	defer daemon.mutex.Unlock()
+	delete(daemon.running, key)
+	end := time.Now()
+	pipeline.status.Running = false
+	pipeline.status.LastEnd = &end
+	pipeline.status.Runs++
+	pipeline.status.Sinks = pipeline.runner.Results
+	if err != nil {
+		log.Print("Pipeline " + name + " is failed: " + err.Error())
+		pipeline.status.LastError = err.Error()
+		pipeline.status.Failures++
+	} else {
+		pipeline.status.LastError = ""
+		pipeline.status.Failures = 0
+	}
+	return pipeline.status.Failures
+}
+
+// backoff delays the next run after repeated failures: the delay is doubled after every consecutive failure (from the
+// second one) up to maxBackoff.
+func backoff(now time.Time, next time.Time, failures int) time.Time {
+	if failures < 2 || next.IsZero() {
+		return next
+	}
+	delay := next.Sub(now)
+	for i := 1; i < failures && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxBackoff && next.Sub(now) < maxBackoff {
+		delay = maxBackoff
+	}
+	return now.Add(delay)
+}
+
+// Statuses returns a copy of the status of all the pipelines.
+func (daemon *Daemon) Statuses() []PipelineStatus {
+	daemon.mutex.Lock()
+	defer daemon.mutex.Unlock()
+	statuses := make([]PipelineStatus, 0, len(daemon.pipelines))
+	for _, pipeline := range daemon.pipelines {
+		statuses = append(statuses, *pipeline.status)
+	}
+	return statuses
+}
+
+// ServeHTTP serves the status of the pipelines as json on /status.
+func (daemon *Daemon) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/status" {
+		http.NotFound(w, r)
+		return
+	}
+	content, err := json.MarshalIndent(daemon.Statuses(), "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(content)
+}
@@ -1,9 +1,12 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"time"
 	"encoding/json"
+	"log"
+	"net/url"
 	"github.com/spf13/cobra"
 )
 
@@ -27,22 +30,25 @@ func init() {
 	var toDbCmd = &cobra.Command{
 		Use:   "todb",
 		Short: "Save latest changes to postgresql db.",
-		Run: func(cmd *cobra.Command, args []string) {
-			db, err := sql.Open("postgres", "postgres://"+pgConfig.Username+":"+pgConfig.Password+"@"+pgConfig.Host+"/"+pgConfig.Db+"?sslmode=disable")
+		RunE: func(cmd *cobra.Command, args []string) error {
+			password, err := resolveSecretFlag(cmd, "pgpassword", "PGPASSWORD")
 			if err != nil {
-				panic("Can' open database " + err.Error())
+				return err
 			}
-			defer db.Close()
-
-			dbAdapter := DbAdapter{Db: db}
-			config := JiraClient{
-				Url:       cmd.Flag("jurl").Value.String(),
-				Username:  cmd.Flag("jusername").Value.String(),
-				Password:  cmd.Flag("jpassword").Value.String(),
-				JQL:       cmd.Flag("jql").Value.String(),
-				RateLimit: 10,
+			pgConfig.Password = password
+			dbAdapter, err := OpenDbAdapter(pgConfig)
+			if err != nil {
+				return err
 			}
-			process(&config, &dbAdapter)
+			defer dbAdapter.Close()
+
+			config, err := FromFlags(cmd)
+			if err != nil {
+				return err
+			}
+			ctx, cancel := signalContext()
+			defer cancel()
+			return process(ctx, &config, dbAdapter)
 
 		},
 	}
@@ -50,38 +56,80 @@ func init() {
 	toDbCmd.Flags().StringVar(&pgConfig.Host, "pgserver", "localhost", "Postgres server host")
 	toDbCmd.Flags().StringVar(&pgConfig.Username, "pgusername", "postgres", "Postgres username")
 	toDbCmd.Flags().StringVar(&pgConfig.Password, "pgpassword", "", "Postgres password")
+	addSecretFlags(toDbCmd.Flags(), "pgpassword", "postgres password")
 	toDbCmd.Flags().StringVar(&pgConfig.Db, "pgdb", "jira", "Postgres database")
 	toDbCmd.Flags().StringVar(&pgConfig.Table, "pgtable", "", "Postgres database")
 
 	rootCmd.AddCommand(toDbCmd)
 }
 
-func (db *DbAdapter) saveIssue(issueItem JiraItem, selector string) error {
+// OpenDbAdapter opens the postgres database. The adapter should be closed after the use.
+func OpenDbAdapter(pgConfig PostgresConfig) (*DbAdapter, error) {
+	db, err := sql.Open("postgres", pgConfig.connectionString())
+	if err != nil {
+		return nil, adapterError("open", err)
+	}
+	return &DbAdapter{Db: db}, nil
+}
+
+func (db *DbAdapter) Close() error {
+	return db.Db.Close()
+}
+
+func (pgConfig PostgresConfig) connectionString() string {
+	connection := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(pgConfig.Username, pgConfig.Password),
+		Host:     pgConfig.Host,
+		Path:     "/" + pgConfig.Db,
+		RawQuery: "sslmode=disable",
+	}
+	return connection.String()
+}
+
+// saveEvent saves the payload of the event to the table of its kind.
+func (db *DbAdapter) saveEvent(ctx context.Context, event *Event, selector string) error {
+	switch item := event.Payload.(type) {
+	case *JiraItem:
+		return db.saveIssue(ctx, *item, selector)
+	case *ChangeItem:
+		return db.saveChange(ctx, *item, selector)
+	case *CommentItem:
+		return db.saveComment(ctx, *item, selector)
+	case *WorklogItem:
+		return db.saveWorklog(ctx, *item, selector)
+	case *AttachmentItem:
+		return db.saveAttachment(ctx, *item, selector)
+	}
+	return nil
+}
+
+func (db *DbAdapter) saveIssue(ctx context.Context, issueItem JiraItem, selector string) error {
 	issue := issueItem.Issue
 	content, err := json.Marshal(issue);
 	if err != nil {
 		return err
 	}
 	key := issue.Key
-	updatedString := issue.Fields["updated"].(string)
-	updated, err := time.Parse("2006-01-02T15:04:05.000-0700", updatedString)
+	updatedField, err := stringField(&issue, "updated")
 	if err != nil {
-		panic("Time could not been parsed " + err.Error())
+		return err
 	}
-
+	updated, err := parseTime(updatedField)
 	if err != nil {
-		print("Json can't be encoded " + err.Error())
+		return err
 	}
-	_, err = db.tx.Exec("INSERT INTO issue (key,value, updated, selector) values ($1,$2,$3,$4) ON CONFLICT (key) DO UPDATE SET value = $2,updated=$3", key, string(content), updated, selector)
+
+	_, err = db.tx.ExecContext(ctx, "INSERT INTO issue (key,value, updated, selector) values ($1,$2,$3,$4) ON CONFLICT (key) DO UPDATE SET value = $2,updated=$3", key, string(content), updated, selector)
 	if err != nil {
-		println("SQL ERROR " + err.Error())
+		return err
 	}
-	println(key + " is updated")
+	log.Print(key + " is updated")
 	return nil
 }
 
-func (adapter DbAdapter) saveChange(item ChangeItem, selector string) error {
-	_, err := adapter.tx.Exec("INSERT INTO change ("+
+func (adapter DbAdapter) saveChange(ctx context.Context, item ChangeItem, selector string) error {
+	_, err := adapter.tx.ExecContext(ctx, "INSERT INTO change ("+
 		"created,selector,toString,fromString,author_name,author_key,history_id,item_index,field) values ($1,$2,$3,$4,$5,$6,$7,$8,$9)",
 		item.Created,
 		selector,
@@ -95,58 +143,91 @@ func (adapter DbAdapter) saveChange(item ChangeItem, selector string) error {
 	return err
 }
 
-func (db *DbAdapter) saveComment(comment CommentItem, selector string) error {
+func (db *DbAdapter) saveComment(ctx context.Context, comment CommentItem, selector string) error {
 	return nil
 }
 
-func (db *DbAdapter) saveChangeItem(issue map[string]interface{}, selector string) error {
-	content, err := json.Marshal(issue);
+func (db *DbAdapter) saveWorklog(ctx context.Context, item WorklogItem, selector string) error {
+	worklog := item.Worklog
+	started, err := parseTime(worklog.Started)
 	if err != nil {
 		return err
 	}
-	key := issue["key"].(string)
-	fields := issue["fields"].(map[string]interface{})
-	updatedString := fields["updated"].(string)
-	updated, err := time.Parse("2006-01-02T15:04:05.000-0700", updatedString)
-	if err != nil {
-		panic("Time could not been parsed " + err.Error())
+	authorName, authorKey := "", ""
+	if worklog.Author != nil {
+		authorName = worklog.Author.DisplayName
+		authorKey = worklog.Author.Key
 	}
+	_, err = db.tx.ExecContext(ctx, "INSERT INTO worklog (id,issue_key,selector,updated,started,time_spent_seconds,comment,author_name,author_key) "+
+		"values ($1,$2,$3,$4,$5,$6,$7,$8,$9) ON CONFLICT (id) DO UPDATE SET "+
+		"updated=$4,started=$5,time_spent_seconds=$6,comment=$7,author_name=$8,author_key=$9",
+		worklog.ID,
+		item.IssueKey,
+		selector,
+		item.Created,
+		started,
+		worklog.TimeSpentSeconds,
+		worklog.Comment,
+		authorName,
+		authorKey)
+	return err
+}
 
-	if err != nil {
-		print("Json can't be encoded " + err.Error())
-	}
-	_, err = db.tx.Exec("INSERT INTO change (key,value, updated, selector) values ($1,$2,$3,$4) ON CONFLICT (key) DO UPDATE SET value = $2,updated=$3", key, string(content), updated, selector)
-	if err != nil {
-		println("SQL ERROR " + err.Error())
-	}
-	println(key + " is updated")
-	return nil
+func (db *DbAdapter) saveAttachment(ctx context.Context, item AttachmentItem, selector string) error {
+	stored := item.Stored
+	_, err := db.tx.ExecContext(ctx, "INSERT INTO attachment (id,issue_key,selector,created,filename,size,mime_type,author_name,sha256,path) "+
+		"values ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10) ON CONFLICT (id) DO NOTHING",
+		stored.ID,
+		item.IssueKey,
+		selector,
+		item.Created,
+		stored.Filename,
+		stored.Size,
+		stored.MimeType,
+		stored.Author,
+		stored.Sha256,
+		stored.Path)
+	return err
 }
 
-func (db *DbAdapter) getLastUpdated(selector string) (time.Time, error) {
-	result, err := db.Db.Query("select updated from issue WHERE selector = $1 order by updated desc limit 1", selector)
+func (db *DbAdapter) getLastUpdated(ctx context.Context, selector string) (time.Time, error) {
+	result, err := db.Db.QueryContext(ctx, "select updated from issue WHERE selector = $1 order by updated desc limit 1", selector)
 	if err != nil {
 		return time.Now(), err
 	}
+	defer result.Close()
 	time := time.Time{}
-	result.Next()
-	result.Scan(&time)
-	return time, nil
+	if result.Next() {
+		err = result.Scan(&time)
+	}
+	return time, err
 
 }
-func (db *DbAdapter) saveLastUpdated(lastUpdated time.Time, selector string) error {
+func (db *DbAdapter) saveLastUpdated(ctx context.Context, lastUpdated time.Time, selector string) error {
 	return nil
 }
 
-func (db *DbAdapter) Commit() error {
-	return db.tx.Commit()
+func (db *DbAdapter) Commit(ctx context.Context) error {
+	tx := db.tx
+	db.tx = nil
+	return tx.Commit()
+}
+
+// Rollback rolls back the open transaction (if any).
+func (db *DbAdapter) Rollback(ctx context.Context) error {
+	if db.tx == nil {
+		return nil
+	}
+	tx := db.tx
+	db.tx = nil
+	return tx.Rollback()
 }
-func (db *DbAdapter) Begin() error {
-	tx, err := db.Db.Begin()
+func (db *DbAdapter) Begin(ctx context.Context) error {
+	tx, err := db.Db.BeginTx(ctx, nil)
 	db.tx = tx
 	return err
 }
 
-func (db *DbAdapter) Finish() error {
+func (db *DbAdapter) Finish(ctx context.Context) error {
 	return nil
 }
\ No newline at end of file
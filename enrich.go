@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/elek/jira-retriever/jiradata"
+)
+
+const defaultWorkers = 4
+
+// EnrichedIssue is an issue from the search results together with the data retrieved by the follow-up calls
+// (comment, changelog and worklog paging, attachment download).
+type EnrichedIssue struct {
+	Issue       *jiradata.Issue
+	Histories   jiradata.Histories
+	Comments    []*jiradata.Comment
+	Worklogs    jiradata.Worklogs
+	Attachments []*jiradata.Attachment
+	Stored      []StoredAttachment
+	// Jira is the instance of the issue.
+	Jira *JiraInstance
+}
+
+// Enricher retrieves the additional data of the issues with a bounded number of parallel workers. All the jira calls
+// go through the same client, so the workers share the rate limiter of the jira host.
+type Enricher struct {
+	client      *JiraClient
+	worklogFeed *WorklogFeed
+	store       *AttachmentStore
+	since       time.Time
+	Workers     int
+}
+
+func NewEnricher(client *JiraClient, since time.Time, store *AttachmentStore) *Enricher {
+	workers := client.Workers
+	if workers <= 0 {
+		workers = defaultWorkers
+	}
+	return &Enricher{
+		client:      client,
+		worklogFeed: NewWorklogFeed(client, since),
+		store:       store,
+		since:       since,
+		Workers:     workers,
+	}
+}
+
+// Enrich retrieves the additional data of all the issues. The result is in the same order as the issues (which is the
+// updated order of the search), independent from the order of the parallel retrieval. The retrieval is stopped on the
+// first error: the calls of the other workers are canceled.
+func (enricher *Enricher) Enrich(ctx context.Context, issues jiradata.Issues) ([]*EnrichedIssue, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make([]*EnrichedIssue, len(issues))
+	var firstErr error
+	var failed sync.Once
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < enricher.Workers && w < len(issues); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range jobs {
+				if ctx.Err() != nil {
+					continue
+				}
+				result, err := enricher.enrichIssue(ctx, issues[idx])
+				if err != nil {
+					failed.Do(func() {
+						firstErr = err
+						cancel()
+					})
+					continue
+				}
+				results[idx] = result
+			}
+		}()
+	}
+jobs:
+	for idx := range issues {
+		select {
+		case jobs <- idx:
+		case <-ctx.Done():
+			break jobs
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return results, nil
+}
+
+func (enricher *Enricher) enrichIssue(ctx context.Context, issue *jiradata.Issue) (*EnrichedIssue, error) {
+	var err error
+	enriched := EnrichedIssue{Issue: issue, Jira: enricher.client.instance()}
+	enriched.Histories, err = issueHistories(ctx, enricher.client, issue)
+	if err != nil {
+		return nil, err
+	}
+	enriched.Comments, err = issueComments(ctx, enricher.client, issue)
+	if err != nil {
+		return nil, err
+	}
+	enriched.Worklogs, err = issueWorklogs(ctx, enricher.client, enricher.worklogFeed, issue)
+	if err != nil {
+		return nil, err
+	}
+	if enricher.store != nil {
+		err = enricher.mirrorAttachments(ctx, &enriched)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &enriched, nil
+}
+
+// mirrorAttachments saves the content of the attachments created after the since time to the attachment store.
+func (enricher *Enricher) mirrorAttachments(ctx context.Context, enriched *EnrichedIssue) error {
+	var attachments jiradata.ListOfAttachment
+	err := convert(enriched.Issue.Fields["attachment"], &attachments, "Attachments of "+enriched.Issue.Key)
+	if err != nil {
+		return err
+	}
+	for _, attachment := range attachments {
+		created, err := parseTime(attachment.Created)
+		if err != nil {
+			return err
+		}
+		if enricher.since.Before(created) {
+			stored, err := enricher.store.Save(ctx, enricher.client, enriched.Issue.Key, attachment, created)
+			if err != nil {
+				return err
+			}
+			enriched.Attachments = append(enriched.Attachments, attachment)
+			enriched.Stored = append(enriched.Stored, stored)
+		}
+	}
+	return nil
+}
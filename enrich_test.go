@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/elek/jira-retriever/jiradata"
+	"github.com/stretchr/testify/assert"
+)
+
+// truncatedIssues returns issues with one embedded comment from the two, so the enricher retrieves the second one.
+func truncatedIssues(count int) jiradata.Issues {
+	var issues jiradata.Issues
+	for i := 1; i <= count; i++ {
+		issues = append(issues, &jiradata.Issue{Key: fmt.Sprintf("HDDS-%d", i), Fields: map[string]interface{}{
+			"comment": map[string]interface{}{"total": 2, "comments": []interface{}{map[string]interface{}{"id": "1"}}},
+		}})
+	}
+	return issues
+}
+
+func TestEnrichOrder(t *testing.T) {
+	client := fakeClient(func(req *http.Request) (int, string) {
+		time.Sleep(time.Duration(rand.Intn(5)) * time.Millisecond)
+		parts := strings.Split(req.URL.Path, "/")
+		key := parts[len(parts)-2]
+		return http.StatusOK, `{"startAt":1,"total":2,"comments":[{"id":"` + key + `"}]}`
+	})
+	client.Workers = 4
+	issues := truncatedIssues(20)
+
+	enriched, err := NewEnricher(client, time.Time{}, nil).Enrich(context.Background(), issues)
+	assert.Nil(t, err)
+	assert.Len(t, enriched, 20)
+	for idx, result := range enriched {
+		assert.Equal(t, issues[idx], result.Issue)
+		assert.Equal(t, issues[idx].Key, result.Comments[1].ID)
+	}
+}
+
+func TestEnrichStopsOnFirstError(t *testing.T) {
+	var mutex sync.Mutex
+	calls, canceled := 0, 0
+	client := fakeClient(func(req *http.Request) (int, string) {
+		if strings.Contains(req.URL.Path, "HDDS-3/") {
+			time.Sleep(time.Duration(rand.Intn(5)) * time.Millisecond)
+			return http.StatusInternalServerError, `{"errorMessages":["Internal error"]}`
+		}
+		mutex.Lock()
+		calls++
+		mutex.Unlock()
+		select {
+		case <-req.Context().Done():
+			mutex.Lock()
+			canceled++
+			mutex.Unlock()
+		case <-time.After(10 * time.Second):
+		}
+		return http.StatusServiceUnavailable, ""
+	})
+	client.Workers = 4
+
+	start := time.Now()
+	_, err := NewEnricher(client, time.Time{}, nil).Enrich(context.Background(), truncatedIssues(20))
+	httpError, ok := err.(*HTTPError)
+	assert.True(t, ok)
+	assert.Equal(t, http.StatusInternalServerError, httpError.StatusCode)
+	assert.True(t, time.Since(start) < 5*time.Second)
+	// only the issues of the running workers are started, and all of them are canceled
+	assert.True(t, calls <= 4)
+	assert.Equal(t, calls, canceled)
+}
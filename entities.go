@@ -0,0 +1,329 @@
+package main
+
+import (
+	"encoding/xml"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/elek/jira-retriever/jiradata"
+)
+
+// Time format of the entities.xml. The times are saved in the time zone of the jira server without zone information,
+// the local time zone is used (set TZ to the zone of the decommissioned server if it's different).
+const entitiesTimeFormat = "2006-01-02 15:04:05"
+
+// entity is one element of the entities.xml. The values are saved either as attributes or, for long texts, as child
+// elements.
+type entity struct {
+	XMLName  xml.Name
+	Attrs    []xml.Attr `xml:",any,attr"`
+	Children []struct {
+		XMLName xml.Name
+		Value   string `xml:",chardata"`
+	} `xml:",any"`
+}
+
+func (e *entity) values() map[string]string {
+	values := make(map[string]string)
+	for _, attr := range e.Attrs {
+		values[attr.Name.Local] = attr.Value
+	}
+	for _, child := range e.Children {
+		values[child.XMLName.Local] = child.Value
+	}
+	return values
+}
+
+// Entities of the backup which are required to rebuild the issues.
+var importedEntities = map[string]bool{
+	"Project":         true,
+	"Issue":           true,
+	"IssueType":       true,
+	"Status":          true,
+	"Priority":        true,
+	"Resolution":      true,
+	"Action":          true,
+	"ChangeGroup":     true,
+	"ChangeItem":      true,
+	"Worklog":         true,
+	"User":            true,
+	"ApplicationUser": true,
+}
+
+// readEntities reads the issues with their comments, changelog and worklogs from the entities.xml of a jira backup.
+// The backup is streamed but the required entities are kept in memory until the end of the file.
+func readEntities(reader io.Reader, file string) ([]*EnrichedIssue, error) {
+	entities := make(map[string][]map[string]string)
+	decoder := xml.NewDecoder(reader)
+	depth := 0
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, &DecodeError{What: "Backup " + file, Err: err}
+		}
+		switch typed := token.(type) {
+		case xml.StartElement:
+			if depth == 0 {
+				depth++
+				continue
+			}
+			if !importedEntities[typed.Name.Local] {
+				err = decoder.Skip()
+			} else {
+				var e entity
+				err = decoder.DecodeElement(&e, &typed)
+				entities[typed.Name.Local] = append(entities[typed.Name.Local], e.values())
+			}
+			if err != nil {
+				return nil, &DecodeError{What: "Backup " + file, Err: err}
+			}
+		case xml.EndElement:
+			depth--
+		}
+	}
+	return newBackup(entities).issues()
+}
+
+// backup is the index of the entities of a jira backup.
+type backup struct {
+	entities  map[string][]map[string]string
+	byId      map[string]map[string]map[string]string
+	userNames map[string]string
+	users     map[string]map[string]string
+}
+
+func newBackup(entities map[string][]map[string]string) *backup {
+	b := backup{
+		entities:  entities,
+		byId:      make(map[string]map[string]map[string]string),
+		userNames: make(map[string]string),
+		users:     make(map[string]map[string]string),
+	}
+	for _, name := range []string{"Project", "IssueType", "Status", "Priority", "Resolution"} {
+		b.byId[name] = make(map[string]map[string]string)
+		for _, values := range entities[name] {
+			b.byId[name][values["id"]] = values
+		}
+	}
+	for _, values := range entities["ApplicationUser"] {
+		b.userNames[values["userKey"]] = values["lowerUserName"]
+	}
+	for _, values := range entities["User"] {
+		b.users[values["lowerUserName"]] = values
+	}
+	return &b
+}
+
+// user returns the user of a user key.
+func (b *backup) user(key string) *jiradata.User {
+	if key == "" {
+		return nil
+	}
+	user := jiradata.User{Key: key, Name: key, DisplayName: key}
+	if name, ok := b.userNames[key]; ok {
+		user.Name = name
+	}
+	if values, ok := b.users[strings.ToLower(user.Name)]; ok {
+		user.Name = values["userName"]
+		user.DisplayName = values["displayName"]
+		user.EmailAddress = values["emailAddress"]
+	}
+	return &user
+}
+
+// named returns the id and name of a referenced entity (eg. status) as an issue field.
+func (b *backup) named(entityName string, id string) map[string]interface{} {
+	field := map[string]interface{}{"id": id}
+	if values, ok := b.byId[entityName][id]; ok {
+		field["name"] = values["name"]
+	}
+	return field
+}
+
+func (b *backup) issues() ([]*EnrichedIssue, error) {
+	issues := make(map[string]*EnrichedIssue)
+	result := make([]*EnrichedIssue, 0, len(b.entities["Issue"]))
+	for _, values := range b.entities["Issue"] {
+		enriched, err := b.issue(values)
+		if err != nil {
+			return nil, err
+		}
+		issues[values["id"]] = enriched
+		result = append(result, enriched)
+	}
+
+	for _, values := range b.entities["Action"] {
+		enriched, ok := issues[values["issue"]]
+		if !ok || values["type"] != "comment" {
+			continue
+		}
+		created, err := parseEntitiesTime(values["created"])
+		if err != nil {
+			return nil, err
+		}
+		updated := created
+		if values["updated"] != "" {
+			updated, err = parseEntitiesTime(values["updated"])
+			if err != nil {
+				return nil, err
+			}
+		}
+		enriched.Comments = append(enriched.Comments, &jiradata.Comment{
+			ID:           values["id"],
+			Author:       b.user(values["author"]),
+			UpdateAuthor: b.user(values["updateauthor"]),
+			Body:         values["body"],
+			Created:      created,
+			Updated:      updated,
+		})
+	}
+
+	histories := make(map[string]*jiradata.ChangeHistory)
+	for _, values := range b.entities["ChangeGroup"] {
+		enriched, ok := issues[values["issue"]]
+		if !ok {
+			continue
+		}
+		created, err := parseEntitiesTime(values["created"])
+		if err != nil {
+			return nil, err
+		}
+		author := b.user(values["author"])
+		if author == nil {
+			author = &jiradata.User{}
+		}
+		history := &jiradata.ChangeHistory{ID: values["id"], Author: author, Created: created}
+		histories[values["id"]] = history
+		enriched.Histories = append(enriched.Histories, history)
+	}
+	for _, values := range b.entities["ChangeItem"] {
+		history, ok := histories[values["group"]]
+		if !ok {
+			continue
+		}
+		history.Items = append(history.Items, &jiradata.ChangeItem{
+			Field:      values["field"],
+			FieldType:  values["fieldtype"],
+			From:       values["oldvalue"],
+			FromString: values["oldstring"],
+			To:         values["newvalue"],
+			ToString:   values["newstring"],
+		})
+	}
+
+	for _, values := range b.entities["Worklog"] {
+		enriched, ok := issues[values["issue"]]
+		if !ok {
+			continue
+		}
+		worklog := jiradata.Worklog{
+			ID:           values["id"],
+			IssueID:      values["issue"],
+			Author:       b.user(values["author"]),
+			UpdateAuthor: b.user(values["updateauthor"]),
+			Comment:      values["body"],
+		}
+		var err error
+		for target, source := range map[*string]string{
+			&worklog.Created: values["created"],
+			&worklog.Updated: values["updated"],
+			&worklog.Started: values["startdate"],
+		} {
+			if source != "" {
+				*target, err = parseEntitiesTime(source)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+		if worklog.Updated == "" {
+			worklog.Updated = worklog.Created
+		}
+		worklog.TimeSpentSeconds, _ = strconv.Atoi(values["timeworked"])
+		enriched.Worklogs = append(enriched.Worklogs, &worklog)
+	}
+
+	for _, enriched := range result {
+		sortEnriched(enriched)
+	}
+	return result, nil
+}
+
+func (b *backup) issue(values map[string]string) (*EnrichedIssue, error) {
+	created, err := parseEntitiesTime(values["created"])
+	if err != nil {
+		return nil, err
+	}
+	updated, err := parseEntitiesTime(values["updated"])
+	if err != nil {
+		return nil, err
+	}
+	project := b.named("Project", values["project"])
+	projectKey := ""
+	if projectValues, ok := b.byId["Project"][values["project"]]; ok {
+		projectKey = projectValues["key"]
+		project["key"] = projectKey
+	}
+	// the key is not saved since jira 6.1, only the project and the number
+	key := values["key"]
+	if key == "" {
+		key = projectKey + "-" + values["number"]
+	}
+	fields := map[string]interface{}{
+		"summary":     values["summary"],
+		"description": values["description"],
+		"created":     created,
+		"updated":     updated,
+		"project":     project,
+		"issuetype":   b.named("IssueType", values["type"]),
+		"status":      b.named("Status", values["status"]),
+		"priority":    b.named("Priority", values["priority"]),
+	}
+	if values["resolution"] != "" {
+		fields["resolution"] = b.named("Resolution", values["resolution"])
+	}
+	for field, userKey := range map[string]string{
+		"reporter": values["reporter"],
+		"assignee": values["assignee"],
+		"creator":  values["creator"],
+	} {
+		if user := b.user(userKey); user != nil {
+			fields[field] = userField(user)
+		}
+	}
+	if _, ok := fields["creator"]; !ok && fields["reporter"] != nil {
+		fields["creator"] = fields["reporter"]
+	}
+	return &EnrichedIssue{Issue: &jiradata.Issue{ID: values["id"], Key: key, Fields: fields}}, nil
+}
+
+// sortEnriched orders the comments, histories and worklogs by creation time as they are returned by the REST api.
+func sortEnriched(enriched *EnrichedIssue) {
+	sort.SliceStable(enriched.Comments, func(a int, b int) bool {
+		return enriched.Comments[a].Created < enriched.Comments[b].Created
+	})
+	sort.SliceStable(enriched.Histories, func(a int, b int) bool {
+		return enriched.Histories[a].Created < enriched.Histories[b].Created
+	})
+	sort.SliceStable(enriched.Worklogs, func(a int, b int) bool {
+		return enriched.Worklogs[a].Created < enriched.Worklogs[b].Created
+	})
+}
+
+// parseEntitiesTime converts the time of the entities.xml (eg. 2018-03-02 14:13:20.0) to the format of the REST api.
+func parseEntitiesTime(value string) (string, error) {
+	if dot := strings.Index(value, "."); dot >= 0 {
+		value = value[:dot]
+	}
+	parsed, err := time.ParseInLocation(entitiesTimeFormat, value, time.Local)
+	if err != nil {
+		return "", &TimeParseError{Value: value, Err: err}
+	}
+	return parsed.Format(timeFormat), nil
+}
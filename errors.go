@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/elek/jira-retriever/jiradata"
+)
+
+// Exit codes of the process. Wrapper scripts (eg. cron jobs) could use them to find out which side of the pipeline
+// is failed.
+const (
+	exitOk = 0
+	// Unclassified error, including invalid flags and configuration.
+	exitFailure = 1
+	// Jira couldn't be reached or responded with an error.
+	exitJiraError = 2
+	// Response of jira couldn't be parsed.
+	exitDecodeError = 3
+	// The adapter (database, slack, ...) is failed.
+	exitAdapterError = 4
+)
+
+// RequestError is returned if the jira server couldn't be reached.
+type RequestError struct {
+	Method string
+	Url    string
+	Err    error
+}
+
+func (e *RequestError) Error() string {
+	return fmt.Sprintf("Jira url %s %s couldn't be opened: %s", e.Method, e.Url, e.Err.Error())
+}
+
+// HTTPError is returned if jira is responded with an error status code. Errors contains the parsed ErrorCollection
+// of the response body (if any).
+type HTTPError struct {
+	Method     string
+	Url        string
+	StatusCode int
+	Errors     *jiradata.ErrorCollection
+}
+
+func (e *HTTPError) Error() string {
+	message := fmt.Sprintf("Jira API is responded with error: HTTP %d (%s %s)", e.StatusCode, e.Method, e.Url)
+	if e.Errors != nil && e.Errors.Error() != "" {
+		message += ": " + e.Errors.Error()
+	}
+	return message
+}
+
+// newHTTPError creates the HTTPError from the response body.
+func newHTTPError(method string, url string, statusCode int, body []byte) *HTTPError {
+	httpError := HTTPError{Method: method, Url: url, StatusCode: statusCode}
+	var errorCollection jiradata.ErrorCollection
+	if err := json.Unmarshal(body, &errorCollection); err == nil {
+		httpError.Errors = &errorCollection
+	}
+	return &httpError
+}
+
+// JiraError is returned if jira is responded with error messages in a successful response.
+type JiraError struct {
+	What     string
+	Messages []string
+}
+
+func (e *JiraError) Error() string {
+	return fmt.Sprintf("%s is failed in jira: %s", e.What, strings.Join(e.Messages, ", "))
+}
+
+// DecodeError is returned if a jira response couldn't be parsed.
+type DecodeError struct {
+	What string
+	Err  error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("%s can't be parsed: %s", e.What, e.Err.Error())
+}
+
+// decode parses the json content to the target and returns DecodeError on failure.
+func decode(content []byte, target interface{}, what string) error {
+	err := json.Unmarshal(content, target)
+	if err != nil {
+		return &DecodeError{What: what, Err: err}
+	}
+	return nil
+}
+
+// stringField returns the string field of the issue or DecodeError if it's missing.
+func stringField(issue *jiradata.Issue, field string) (string, error) {
+	value, ok := issue.Fields[field].(string)
+	if !ok {
+		return "", &DecodeError{What: "Issue " + issue.Key, Err: errors.New(field + " field is missing")}
+	}
+	return value, nil
+}
+
+// convert converts the generic (map based) json structure to the target type. It's used to parse the fields of the
+// issues.
+func convert(source interface{}, target interface{}, what string) error {
+	marshalled, err := json.Marshal(source)
+	if err != nil {
+		return &DecodeError{What: what, Err: err}
+	}
+	return decode(marshalled, target, what)
+}
+
+// TimeParseError is returned if a timestamp from jira has unexpected format.
+type TimeParseError struct {
+	Value string
+	Err   error
+}
+
+func (e *TimeParseError) Error() string {
+	return fmt.Sprintf("Time %s could not been parsed: %s", e.Value, e.Err.Error())
+}
+
+// parseTime parses a jira timestamp.
+func parseTime(value string) (time.Time, error) {
+	parsed, err := time.Parse(timeFormat, value)
+	if err != nil {
+		return parsed, &TimeParseError{Value: value, Err: err}
+	}
+	return parsed, nil
+}
+
+// AdapterError is returned if an adapter operation is failed.
+type AdapterError struct {
+	Op  string
+	Err error
+}
+
+func (e *AdapterError) Error() string {
+	return fmt.Sprintf("Adapter operation %s is failed: %s", e.Op, e.Err.Error())
+}
+
+// adapterError wraps the error of the adapter operation (or returns nil if there is no error).
+func adapterError(op string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, wrapped := err.(*AdapterError); wrapped {
+		return err
+	}
+	return &AdapterError{Op: op, Err: err}
+}
+
+// exitCode returns the process exit code for the error.
+func exitCode(err error) int {
+	switch err.(type) {
+	case nil:
+		return exitOk
+	case *RequestError, *HTTPError, *JiraError:
+		return exitJiraError
+	case *DecodeError, *TimeParseError:
+		return exitDecodeError
+	case *AdapterError:
+		return exitAdapterError
+	default:
+		return exitFailure
+	}
+}
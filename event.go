@@ -0,0 +1,251 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/elek/jira-retriever/jiradata"
+)
+
+// EventVersion is the version of the event envelope. It's increased on incompatible changes of the Event structure.
+const EventVersion = 1
+
+// EventType is the semantic type of an event.
+type EventType string
+
+// Supported event types. The change events are derived from the changelog items of the issues (see changeEventType).
+const (
+	EventIssueCreated       EventType = "issue.created"
+	EventIssueUpdated       EventType = "issue.updated"
+	EventAssigned           EventType = "issue.assigned"
+	EventStatusChanged      EventType = "issue.status_changed"
+	EventResolved           EventType = "issue.resolved"
+	EventReopened           EventType = "issue.reopened"
+	EventFixVersionChanged  EventType = "issue.fix_version_changed"
+	EventLinkAdded          EventType = "issue.link_added"
+	EventAttachmentAdded    EventType = "issue.attachment_added"
+	EventFieldChanged       EventType = "issue.field_changed"
+	EventCommentAdded       EventType = "comment.added"
+	EventCommentUpdated     EventType = "comment.updated"
+	EventWorkLogged         EventType = "worklog.logged"
+	EventAttachmentMirrored EventType = "attachment.mirrored"
+)
+
+// JiraInstance is the jira server of the events. It's used to create the issue links and the message headers.
+type JiraInstance struct {
+	Name        string `json:"name,omitempty"`
+	DisplayName string `json:"displayName,omitempty"`
+	// Url is the url of the jira connection.
+	Url string `json:"url,omitempty"`
+	// BrowseUrl is the base url of the issue links if it's different from the url of the issues.
+	BrowseUrl string `json:"browseUrl,omitempty"`
+	// Icon is a short icon of the instance, eg. a slack emoji.
+	Icon string `json:"icon,omitempty"`
+}
+
+// Actor is the user who caused the event.
+type Actor struct {
+	Key         string `json:"key,omitempty"`
+	Name        string `json:"name,omitempty"`
+	DisplayName string `json:"displayName,omitempty"`
+}
+
+// Event is the envelope of all the changes sent to the adapters. The payload is the raw item: *JiraItem (issue events),
+// *ChangeItem (changelog based events), *CommentItem, *WorklogItem or *AttachmentItem.
+type Event struct {
+	Version int `json:"version"`
+	// ID is stable: the same change has the same id independent from the source (polling, webhook, import) and from
+	// the number of the retrievals.
+	ID           string                   `json:"id"`
+	Type         EventType                `json:"type"`
+	IssueKey     string                   `json:"issueKey"`
+	IssueSummary string                   `json:"issueSummary"`
+	Actor        Actor                    `json:"actor"`
+	Timestamp    time.Time                `json:"timestamp"`
+	Payload      WithBaseIssueInformation `json:"payload"`
+	Jira         *JiraInstance            `json:"jira,omitempty"`
+	// Issue is the issue of the event (if it's known), used by the filters.
+	Issue *jiradata.Issue `json:"-"`
+}
+
+func (event *Event) GetIssueKey() string {
+	return event.IssueKey
+}
+func (event *Event) GetIssueSummary() string {
+	return event.IssueSummary
+}
+func (event *Event) GetCreated() time.Time {
+	return event.Timestamp
+}
+
+func newEvent(id string, eventType EventType, actor Actor, payload WithBaseIssueInformation) *Event {
+	return &Event{
+		Version:      EventVersion,
+		ID:           id,
+		Type:         eventType,
+		IssueKey:     payload.GetIssueKey(),
+		IssueSummary: payload.GetIssueSummary(),
+		Actor:        actor,
+		Timestamp:    payload.GetCreated(),
+		Payload:      payload,
+	}
+}
+
+func actorOf(user *jiradata.User) Actor {
+	if user == nil {
+		return Actor{}
+	}
+	return Actor{Key: user.Key, Name: user.Name, DisplayName: user.DisplayName}
+}
+
+// actorOfField returns the user of an issue field (eg. creator).
+func actorOfField(field interface{}) Actor {
+	var user jiradata.User
+	if convert(field, &user, "User") != nil {
+		return Actor{}
+	}
+	return actorOf(&user)
+}
+
+// issueEvent returns the event of the issue itself. The issue is new if it's created after the since time of the
+// retrieval, otherwise the event is a snapshot of the updated issue.
+func issueEvent(item *JiraItem, since time.Time) *Event {
+	if item.Created.After(since) {
+		creator := item.Issue.Fields["creator"]
+		if creator == nil {
+			creator = item.Issue.Fields["reporter"]
+		}
+		return newEvent("issue:"+item.Issue.Key+":created", EventIssueCreated, actorOfField(creator), item)
+	}
+	event := newEvent(fmt.Sprintf("issue:%s:%d", item.Issue.Key, item.updated().UnixNano()/int64(time.Millisecond)),
+		EventIssueUpdated, Actor{}, item)
+	event.Timestamp = item.updated()
+	return event
+}
+
+// changeEvent returns the semantic event of a changelog item.
+func changeEvent(item *ChangeItem) *Event {
+	id := fmt.Sprintf("change:%d:%d", item.HistoryId, item.ItemIndex)
+	return newEvent(id, changeEventType(item), Actor{Key: item.AuthorKey, DisplayName: item.AuthorName}, item)
+}
+
+// changeEventType derives the semantic type from the changed field. Resolving and reopening are recognized by the
+// resolution field (the status transition is a separate item of the same change).
+func changeEventType(item *ChangeItem) EventType {
+	switch strings.ToLower(item.Field) {
+	case "assignee":
+		return EventAssigned
+	case "status":
+		return EventStatusChanged
+	case "resolution":
+		if item.To != "" || item.ToString != "" {
+			return EventResolved
+		}
+		return EventReopened
+	case "fix version", "fixversions":
+		return EventFixVersionChanged
+	case "link":
+		if item.To != "" {
+			return EventLinkAdded
+		}
+	case "attachment":
+		if item.To != "" {
+			return EventAttachmentAdded
+		}
+	}
+	return EventFieldChanged
+}
+
+func commentEvent(item *CommentItem, eventType EventType) *Event {
+	id := "comment:" + item.Comment.ID
+	actor := actorOf(item.Comment.Author)
+	if eventType == EventCommentUpdated {
+		id += ":" + item.Comment.Updated
+		actor = actorOf(item.Comment.UpdateAuthor)
+	}
+	event := newEvent(id, eventType, actor, item)
+	if eventType == EventCommentUpdated {
+		if updated, err := parseTime(item.Comment.Updated); err == nil {
+			event.Timestamp = updated
+		}
+	}
+	return event
+}
+
+func worklogEvent(item *WorklogItem) *Event {
+	return newEvent("worklog:"+item.Worklog.ID+":"+item.Worklog.Updated, EventWorkLogged, actorOf(item.Worklog.Author),
+		item)
+}
+
+func attachmentEvent(item *AttachmentItem) *Event {
+	return newEvent(fmt.Sprintf("attachment:%d:mirrored", item.Attachment.ID), EventAttachmentMirrored,
+		actorOf(item.Attachment.Author), item)
+}
+
+// BrowseUrl returns the link of the issue. The base url is the configured browse url, the base url of the issue (self)
+// or the url of the jira connection. It returns an empty string if none of them is known.
+func (event *Event) BrowseUrl() string {
+	base := ""
+	if event.Jira != nil {
+		base = event.Jira.BrowseUrl
+	}
+	if base == "" && event.Issue != nil {
+		if idx := strings.Index(event.Issue.Self, "/rest/api/"); idx > 0 {
+			base = event.Issue.Self[:idx]
+		}
+	}
+	if base == "" && event.Jira != nil {
+		base = event.Jira.Url
+	}
+	if base == "" {
+		return ""
+	}
+	return strings.TrimSuffix(base, "/") + "/browse/" + event.IssueKey
+}
+
+// Title returns the human readable title of the event.
+func (event *Event) Title() string {
+	switch event.Type {
+	case EventIssueCreated:
+		return "Issue is created"
+	case EventIssueUpdated:
+		return "Issue is updated"
+	case EventAssigned:
+		return "Assigned"
+	case EventStatusChanged:
+		return "Status is changed"
+	case EventResolved:
+		return "Resolved"
+	case EventReopened:
+		return "Reopened"
+	case EventFixVersionChanged:
+		return "Fix version is changed"
+	case EventLinkAdded:
+		return "Link is added"
+	case EventAttachmentAdded:
+		return "Attachment is added"
+	case EventCommentAdded:
+		return "Comment"
+	case EventCommentUpdated:
+		return "Comment is updated"
+	case EventWorkLogged:
+		return "Work logged"
+	case EventAttachmentMirrored:
+		return "Attachment is mirrored"
+	}
+	if change, ok := event.Payload.(*ChangeItem); ok {
+		return change.Field + " field is changed"
+	}
+	return string(event.Type)
+}
+
+// updated returns the last update time of the issue.
+func (item *JiraItem) updated() time.Time {
+	if value, ok := item.Issue.Fields["updated"].(string); ok {
+		if updated, err := parseTime(value); err == nil {
+			return updated
+		}
+	}
+	return item.Created
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/elek/jira-retriever/jiradata"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChangeEventType(t *testing.T) {
+	assert.Equal(t, EventAssigned, changeEventType(&ChangeItem{Field: "assignee", To: "elek"}))
+	assert.Equal(t, EventStatusChanged, changeEventType(&ChangeItem{Field: "status", To: "5"}))
+	assert.Equal(t, EventResolved, changeEventType(&ChangeItem{Field: "resolution", To: "1", ToString: "Fixed"}))
+	assert.Equal(t, EventReopened, changeEventType(&ChangeItem{Field: "resolution", From: "1", FromString: "Fixed"}))
+	assert.Equal(t, EventFixVersionChanged, changeEventType(&ChangeItem{Field: "Fix Version", ToString: "0.3.0"}))
+	assert.Equal(t, EventLinkAdded, changeEventType(&ChangeItem{Field: "Link", To: "HDDS-2"}))
+	assert.Equal(t, EventFieldChanged, changeEventType(&ChangeItem{Field: "Link", From: "HDDS-2"}))
+	assert.Equal(t, EventFieldChanged, changeEventType(&ChangeItem{Field: "priority", ToString: "Major"}))
+}
+
+func TestIssueEvent(t *testing.T) {
+	created := time.Date(2018, 3, 1, 10, 0, 0, 0, time.UTC)
+	item := &JiraItem{
+		BaseIssueInfo: BaseIssueInfo{IssueKey: "HDDS-1", Created: created},
+		Issue: jiradata.Issue{Key: "HDDS-1", Fields: map[string]interface{}{
+			"updated": "2018-03-02T10:00:00.000+0000",
+			"creator": map[string]interface{}{"key": "elek", "displayName": "Marton Elek"},
+		}},
+	}
+
+	event := issueEvent(item, created.Add(-time.Hour))
+	assert.Equal(t, EventIssueCreated, event.Type)
+	assert.Equal(t, "issue:HDDS-1:created", event.ID)
+	assert.Equal(t, "Marton Elek", event.Actor.DisplayName)
+	assert.Equal(t, EventVersion, event.Version)
+
+	event = issueEvent(item, created)
+	assert.Equal(t, EventIssueUpdated, event.Type)
+	assert.Equal(t, "issue:HDDS-1:1519984800000", event.ID)
+	assert.Equal(t, created.Add(24*time.Hour), event.Timestamp.UTC())
+}
+
+func TestIssueEventsOfIncompleteIssue(t *testing.T) {
+	issue := &jiradata.Issue{Key: "HDDS-1", Fields: map[string]interface{}{
+		"created": "2018-03-01T10:00:00.000+0000",
+		"updated": "2018-03-02T10:00:00.000+0000",
+	}}
+	_, _, err := issueEvents(time.Time{}, &EnrichedIssue{Issue: issue})
+	assert.Equal(t, exitDecodeError, exitCode(err))
+
+	// the author of the automatic changes is missing
+	issue.Fields["summary"] = "Test issue"
+	history := &jiradata.ChangeHistory{ID: "1", Created: "2018-03-02T10:00:00.000+0000",
+		Items: []*jiradata.ChangeItem{{Field: "status", ToString: "Resolved"}}}
+	events, _, err := issueEvents(time.Time{}, &EnrichedIssue{Issue: issue, Histories: jiradata.Histories{history}})
+	assert.Nil(t, err)
+	assert.Len(t, events, 2)
+	assert.Equal(t, "", events[1].Actor.DisplayName)
+}
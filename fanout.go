@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+)
+
+// Sink is a named adapter of a fan-out.
+type Sink struct {
+	Name    string
+	Adapter Adapter
+}
+
+// SinkResult is the outcome of a run for one sink.
+type SinkResult struct {
+	Name  string `json:"name"`
+	Items int    `json:"items"`
+	Error string `json:"error,omitempty"`
+}
+
+// SinkErrors is returned if some of the sinks of a fan-out are failed.
+type SinkErrors struct {
+	Results []SinkResult
+}
+
+func (e *SinkErrors) Error() string {
+	var failed []string
+	for _, result := range e.Results {
+		if result.Error != "" {
+			failed = append(failed, result.Name+": "+result.Error)
+		}
+	}
+	return "Sinks are failed: " + strings.Join(failed, ", ")
+}
+
+type fanOutSink struct {
+	Sink
+	since time.Time
+	items int
+	err   error
+}
+
+// FanOutAdapter sends the changes of one jira retrieval to multiple adapters. Every sink has its own transactions and
+// last updated time: the query starts from the oldest last updated time and a sink receives only the changes which
+// are newer than its own last updated time.
+//
+// A failed sink is skipped for the rest of the run (and its last updated time is not saved), the other sinks are
+// continued. The run is stopped only if all the sinks are failed.
+type FanOutAdapter struct {
+	sinks []*fanOutSink
+}
+
+func NewFanOutAdapter(sinks ...Sink) *FanOutAdapter {
+	fanOut := FanOutAdapter{}
+	for _, sink := range sinks {
+		fanOut.Add(sink)
+	}
+	return &fanOut
+}
+
+// Add adds a sink to the fan-out. It should be called before the run.
+func (fanOut *FanOutAdapter) Add(sink Sink) {
+	fanOut.sinks = append(fanOut.sinks, &fanOutSink{Sink: sink})
+}
+
+// AddFailed adds a sink which couldn't be created, to report it in the results.
+func (fanOut *FanOutAdapter) AddFailed(name string, err error) {
+	fanOut.sinks = append(fanOut.sinks, &fanOutSink{Sink: Sink{Name: name}, err: err})
+}
+
+// Results returns the outcome of the run for all the sinks.
+func (fanOut *FanOutAdapter) Results() []SinkResult {
+	results := make([]SinkResult, 0, len(fanOut.sinks))
+	for _, sink := range fanOut.sinks {
+		result := SinkResult{Name: sink.Name, Items: sink.items}
+		if sink.err != nil {
+			result.Error = sink.err.Error()
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// each calls the function for all the sinks which are not failed. It returns error only if all the sinks are failed.
+func (fanOut *FanOutAdapter) each(op string, call func(sink *fanOutSink) error) error {
+	active := 0
+	for _, sink := range fanOut.sinks {
+		if sink.err != nil {
+			continue
+		}
+		err := call(sink)
+		if err != nil {
+			log.Print("Sink " + sink.Name + " is failed (" + op + "), skipping it for the rest of the run: " + err.Error())
+			sink.err = adapterError(op, err)
+			continue
+		}
+		active++
+	}
+	if active == 0 {
+		return &SinkErrors{Results: fanOut.Results()}
+	}
+	return nil
+}
+
+// saveEvent sends the event to the sinks which don't have it yet. The issue snapshots are sent to every sink, a new
+// issue is sent as updated issue to the sinks which are ahead of its creation.
+func (fanOut *FanOutAdapter) saveEvent(ctx context.Context, event *Event, selector string) error {
+	return fanOut.each("saveEvent", func(sink *fanOutSink) error {
+		sinkEvent := event
+		switch event.Type {
+		case EventIssueCreated:
+			sinkEvent = issueEvent(event.Payload.(*JiraItem), sink.since)
+			sinkEvent.Issue = event.Issue
+			sinkEvent.Jira = event.Jira
+		case EventIssueUpdated:
+		default:
+			if !event.Timestamp.After(sink.since) {
+				return nil
+			}
+		}
+		sink.items++
+		err := sink.Adapter.saveEvent(ctx, sinkEvent, selector)
+		if err != nil {
+			rollback(ctx, sink.Adapter)
+		}
+		return err
+	})
+}
+
+// getLastUpdated returns the oldest last updated time of the sinks.
+func (fanOut *FanOutAdapter) getLastUpdated(ctx context.Context, selector string) (time.Time, error) {
+	var oldest time.Time
+	first := true
+	err := fanOut.each("getLastUpdated", func(sink *fanOutSink) error {
+		since, err := sink.Adapter.getLastUpdated(ctx, selector)
+		if err != nil {
+			return err
+		}
+		sink.since = since
+		if first || since.Before(oldest) {
+			oldest = since
+			first = false
+		}
+		return nil
+	})
+	return oldest, err
+}
+
+// saveLastUpdated saves the last updated time of the sinks which are not failed (including the failures of Finish)
+// and reports the result of the run. The last updated time of a sink is never moved back. It returns SinkErrors if any
+// of the sinks is failed.
+func (fanOut *FanOutAdapter) saveLastUpdated(ctx context.Context, lastUpdated time.Time, selector string) error {
+	err := fanOut.each("saveLastUpdated", func(sink *fanOutSink) error {
+		if lastUpdated.Before(sink.since) {
+			return nil
+		}
+		return sink.Adapter.saveLastUpdated(ctx, lastUpdated, selector)
+	})
+	failed := false
+	for _, result := range fanOut.Results() {
+		if result.Error != "" {
+			failed = true
+			log.Print("Sink " + result.Name + " is failed: " + result.Error)
+		} else {
+			log.Printf("Sink %s is finished, %d items are sent", result.Name, result.Items)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if failed {
+		return fanOut.Err()
+	}
+	return nil
+}
+
+// Err returns SinkErrors if any of the sinks is failed.
+func (fanOut *FanOutAdapter) Err() error {
+	for _, sink := range fanOut.sinks {
+		if sink.err != nil {
+			return &SinkErrors{Results: fanOut.Results()}
+		}
+	}
+	return nil
+}
+
+func (fanOut *FanOutAdapter) Begin(ctx context.Context) error {
+	return fanOut.each("Begin", func(sink *fanOutSink) error {
+		return sink.Adapter.Begin(ctx)
+	})
+}
+
+func (fanOut *FanOutAdapter) Commit(ctx context.Context) error {
+	return fanOut.each("Commit", func(sink *fanOutSink) error {
+		err := sink.Adapter.Commit(ctx)
+		if err != nil {
+			rollback(ctx, sink.Adapter)
+		}
+		return err
+	})
+}
+
+// Rollback rolls back the transaction of all the sinks. The failed sinks are already rolled back at the failure.
+func (fanOut *FanOutAdapter) Rollback(ctx context.Context) error {
+	for _, sink := range fanOut.sinks {
+		if sink.Adapter != nil {
+			rollback(ctx, sink.Adapter)
+		}
+	}
+	return nil
+}
+
+// Finish finishes all the sinks. A sink failed here is skipped by saveLastUpdated, so its changes are sent again by
+// the next run.
+func (fanOut *FanOutAdapter) Finish(ctx context.Context) error {
+	return fanOut.each("Finish", func(sink *fanOutSink) error {
+		return sink.Adapter.Finish(ctx)
+	})
+}
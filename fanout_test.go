@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/elek/jira-retriever/jiradata"
+	"github.com/stretchr/testify/assert"
+)
+
+// stateHome uses a temporary home directory for the state files of the file based sinks.
+func stateHome(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "jira-retriever")
+	assert.Nil(t, err)
+	home := os.Getenv("HOME")
+	os.Setenv("HOME", dir)
+	return func() {
+		os.Setenv("HOME", home)
+		os.RemoveAll(dir)
+	}
+}
+
+func fanOutSource(times ...time.Time) Source {
+	issue := &jiradata.Issue{Key: "HDDS-1", Fields: map[string]interface{}{}}
+	source := &BatchSource{}
+	for _, created := range times {
+		event := changeEvent(&ChangeItem{BaseIssueInfo: BaseIssueInfo{IssueKey: "HDDS-1", Created: created}})
+		event.Issue = issue
+		source.Batches = append(source.Batches, &Batch{Events: []*Event{event}, Cursor: created})
+	}
+	return source
+}
+
+func TestFanOutAdapter(t *testing.T) {
+	defer stateHome(t)()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"ok": false, "error": "service_unavailable"}`))
+	}))
+	defer server.Close()
+	defer func(url string) { slackApiUrl = url }(slackApiUrl)
+	slackApiUrl = server.URL + "/"
+
+	first := time.Date(2018, 3, 1, 10, 0, 0, 0, time.UTC)
+	second := first.Add(time.Hour)
+	earlier := first.Add(-time.Hour)
+
+	behind := NewConsoleAdapter()
+	behind.Sink = "behind"
+	ahead := NewConsoleAdapter()
+	ahead.Sink = "ahead"
+	slack := NewSlackAdapter("ozone", "token")
+	slack.Layout = layoutBlocks
+	slack.Sink = "slack"
+	assert.Nil(t, CreateSinkFileState("selector", "behind").write(earlier))
+	assert.Nil(t, CreateSinkFileState("selector", "ahead").write(first))
+	assert.Nil(t, CreateSinkFileState("selector", "slack").write(earlier))
+	fanOut := NewFanOutAdapter(Sink{"behind", behind}, Sink{"ahead", ahead}, Sink{"slack", slack})
+
+	ctx := context.Background()
+	since, err := fanOut.getLastUpdated(ctx, "selector")
+	assert.Nil(t, err)
+	assert.Equal(t, earlier.Unix(), since.Unix())
+
+	err = consume(ctx, fanOutSource(first, second), fanOut, "selector", since)
+	assert.Equal(t, exitAdapterError, exitCode(err))
+	sinkErrors, ok := err.(*AdapterError).Err.(*SinkErrors)
+	assert.True(t, ok)
+
+	// the sink which is ahead receives only the new changes
+	assert.Equal(t, 2, len(behind.Events))
+	assert.Equal(t, 1, len(ahead.Events))
+	assert.Equal(t, 2, len(slack.Events))
+	for _, name := range []string{"behind", "ahead"} {
+		saved, err := CreateSinkFileState("selector", name).read()
+		assert.Nil(t, err)
+		assert.Equal(t, second.Unix(), saved.Unix(), name)
+	}
+	// the failed sink keeps its last updated time, the changes are sent again by the next run
+	saved, err := CreateSinkFileState("selector", "slack").read()
+	assert.Nil(t, err)
+	assert.Equal(t, earlier.Unix(), saved.Unix())
+	assert.Equal(t, "", sinkErrors.Results[0].Error)
+	assert.Equal(t, "", sinkErrors.Results[1].Error)
+	assert.Contains(t, sinkErrors.Results[2].Error, "service_unavailable")
+}
+
+func TestSinkFileStateFallback(t *testing.T) {
+	defer stateHome(t)()
+	shared := time.Date(2018, 3, 1, 10, 0, 0, 0, time.UTC)
+	assert.Nil(t, CreateFileState("selector").write(shared))
+
+	// the state shared by the sinks is used until the sink saves its own state
+	state := CreateSinkFileState("selector", "console-0")
+	since, err := state.read()
+	assert.Nil(t, err)
+	assert.Equal(t, shared.Unix(), since.Unix())
+
+	assert.Nil(t, state.write(shared.Add(time.Hour)))
+	since, err = CreateSinkFileState("selector", "slack-1").read()
+	assert.Nil(t, err)
+	assert.Equal(t, shared.Unix(), since.Unix())
+}
@@ -13,6 +13,8 @@ import (
 
 type FileState struct {
 	FileName string
+	// Fallback is read if the state file doesn't exist yet.
+	Fallback *FileState
 }
 
 func CreateFileState(selector string) *FileState {
@@ -24,8 +26,23 @@ func CreateFileState(selector string) *FileState {
 	return &state
 
 }
+
+// CreateSinkFileState returns the state of one sink of a pipeline. The sinks have their own state files, the state
+// shared by the sinks of earlier versions is used until the first save.
+func CreateSinkFileState(selector string, sink string) *FileState {
+	if sink == "" {
+		return CreateFileState(selector)
+	}
+	state := CreateFileState(selector + "-" + getHash(sink))
+	state.Fallback = CreateFileState(selector)
+	return state
+}
+
 func (fileState *FileState) read() (time.Time, error) {
 	if _, err := os.Stat(fileState.FileName); os.IsNotExist(err) {
+		if fileState.Fallback != nil {
+			return fileState.Fallback.read()
+		}
 		return time.Now().Add(time.Duration(-24*5) * time.Hour), nil
 	}
 	lastTime, err := ioutil.ReadFile(fileState.FileName) // just pass the file name
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// FilterConfig selects the events of a sink. An event is sent to the sink if it matches any of the include rules (or
+// there is no include rule) and it doesn't match any of the exclude rules.
+type FilterConfig struct {
+	Include []*FilterRule `yaml:"include"`
+	Exclude []*FilterRule `yaml:"exclude"`
+}
+
+// FilterRule matches an event if all the conditions of the rule are matched. A condition with multiple values is
+// matched if any of the values is matched. The values are compared case insensitively.
+type FilterRule struct {
+	// Event type, with optional wildcard (eg. comment.*)
+	Type StringList `yaml:"type"`
+	// Key, name or display name of the actor
+	Author StringList `yaml:"author"`
+	// Changed field (only the changelog based events have field)
+	Field     StringList `yaml:"field"`
+	Project   StringList `yaml:"project"`
+	Component StringList `yaml:"component"`
+	Label     StringList `yaml:"label"`
+	IssueType StringList `yaml:"issuetype"`
+	Priority  StringList `yaml:"priority"`
+	// Regular expression of the text of the event (comment, worklog comment, new/old value or description)
+	Body string `yaml:"body"`
+	body *regexp.Regexp
+}
+
+// StringList is a list of strings in the config file which could also be defined as a single value.
+type StringList []string
+
+func (list *StringList) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var value string
+	if err := unmarshal(&value); err == nil {
+		*list = StringList{value}
+		return nil
+	}
+	var values []string
+	if err := unmarshal(&values); err != nil {
+		return err
+	}
+	*list = values
+	return nil
+}
+
+// UnmarshalYAML reads the rule and compiles the body expression.
+func (rule *FilterRule) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain FilterRule
+	if err := unmarshal((*plain)(rule)); err != nil {
+		return err
+	}
+	return rule.compile()
+}
+
+func (rule *FilterRule) compile() error {
+	if rule.Body == "" {
+		return nil
+	}
+	var err error
+	rule.body, err = regexp.Compile(rule.Body)
+	if err != nil {
+		return errors.New("Body expression of the filter is invalid: " + err.Error())
+	}
+	return nil
+}
+
+// Accept returns true if the event should be sent to the sink.
+func (filter *FilterConfig) Accept(event *Event) bool {
+	if filter == nil {
+		return true
+	}
+	for _, rule := range filter.Exclude {
+		if rule.Match(event) {
+			return false
+		}
+	}
+	if len(filter.Include) == 0 {
+		return true
+	}
+	for _, rule := range filter.Include {
+		if rule.Match(event) {
+			return true
+		}
+	}
+	return false
+}
+
+// Match returns true if all the conditions of the rule are matched by the event.
+func (rule *FilterRule) Match(event *Event) bool {
+	if len(rule.Type) > 0 && !rule.Type.matchType(event.Type) {
+		return false
+	}
+	if len(rule.Author) > 0 && !rule.Author.contains(event.Actor.Key, event.Actor.Name, event.Actor.DisplayName) {
+		return false
+	}
+	if len(rule.Field) > 0 {
+		change, ok := event.Payload.(*ChangeItem)
+		if !ok || !rule.Field.contains(change.Field) {
+			return false
+		}
+	}
+	conditions := []struct {
+		values StringList
+		actual func(*Event) []string
+	}{
+		{rule.Project, eventProject},
+		{rule.Component, eventComponents},
+		{rule.Label, eventLabels},
+		{rule.IssueType, func(event *Event) []string { return eventField(event, "issuetype") }},
+		{rule.Priority, func(event *Event) []string { return eventField(event, "priority") }},
+	}
+	for _, condition := range conditions {
+		if len(condition.values) > 0 && !condition.values.contains(condition.actual(event)...) {
+			return false
+		}
+	}
+	if rule.body != nil && !rule.body.MatchString(eventBody(event)) {
+		return false
+	}
+	return true
+}
+
+// contains returns true if any of the values is in the list.
+func (list StringList) contains(values ...string) bool {
+	for _, expected := range list {
+		for _, value := range values {
+			if value != "" && strings.EqualFold(expected, value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (list StringList) matchType(eventType EventType) bool {
+	for _, pattern := range list {
+		if matched, _ := path.Match(strings.ToLower(pattern), string(eventType)); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// eventProject returns the key and the name of the project. Without the issue fields the project key is taken from
+// the issue key.
+func eventProject(event *Event) []string {
+	values := eventField(event, "project")
+	if idx := strings.LastIndex(event.IssueKey, "-"); idx > 0 {
+		values = append(values, event.IssueKey[:idx])
+	}
+	return values
+}
+
+func eventComponents(event *Event) []string {
+	var values []string
+	if event.Issue == nil {
+		return values
+	}
+	components, _ := event.Issue.Fields["components"].([]interface{})
+	for _, component := range components {
+		values = append(values, namesOf(component)...)
+	}
+	return values
+}
+
+func eventLabels(event *Event) []string {
+	var values []string
+	if event.Issue == nil {
+		return values
+	}
+	labels, _ := event.Issue.Fields["labels"].([]interface{})
+	for _, label := range labels {
+		if value, ok := label.(string); ok {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// eventField returns the name (and key) of an issue field, eg. issuetype or priority.
+func eventField(event *Event, field string) []string {
+	if event.Issue == nil {
+		return nil
+	}
+	return namesOf(event.Issue.Fields[field])
+}
+
+func namesOf(value interface{}) []string {
+	var names []string
+	switch typed := value.(type) {
+	case string:
+		names = append(names, typed)
+	case map[string]interface{}:
+		for _, key := range []string{"key", "name", "value"} {
+			if name, ok := typed[key].(string); ok {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// eventBody returns the text of the event which is matched by the body expression.
+func eventBody(event *Event) string {
+	switch item := event.Payload.(type) {
+	case *CommentItem:
+		return item.Comment.Body
+	case *WorklogItem:
+		return item.Worklog.Comment
+	case *ChangeItem:
+		return item.FromString + "\n" + item.ToString
+	case *JiraItem:
+		description, _ := item.Issue.Fields["description"].(string)
+		return description
+	}
+	return ""
+}
+
+// FilterAdapter sends only the events accepted by the filter to the adapter.
+type FilterAdapter struct {
+	Adapter
+	Filter *FilterConfig
+}
+
+func (filterAdapter *FilterAdapter) saveEvent(ctx context.Context, event *Event, selector string) error {
+	if !filterAdapter.Filter.Accept(event) {
+		return nil
+	}
+	return filterAdapter.Adapter.saveEvent(ctx, event, selector)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/elek/jira-retriever/jiradata"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+func TestFilterAccept(t *testing.T) {
+	var filter FilterConfig
+	err := yaml.UnmarshalStrict([]byte(`
+include:
+  - project: HDDS
+    component: [SCM, OM]
+  - type: issue.*
+exclude:
+  - author: [genericqa, Hadoop QA]
+    type: comment.*
+  - body: "(?i)^\\+1 overall"
+`), &filter)
+	assert.Nil(t, err)
+
+	issue := &jiradata.Issue{Key: "HDDS-1", Fields: map[string]interface{}{
+		"components": []interface{}{map[string]interface{}{"name": "SCM"}},
+	}}
+	comment := func(author string, body string) *Event {
+		event := commentEvent(&CommentItem{
+			BaseIssueInfo: BaseIssueInfo{IssueKey: "HDDS-1"},
+			Comment:       jiradata.Comment{ID: "1", Body: body, Author: &jiradata.User{DisplayName: author}},
+		}, EventCommentAdded)
+		event.Issue = issue
+		return event
+	}
+
+	assert.True(t, filter.Accept(comment("Marton Elek", "LGTM")))
+	assert.False(t, filter.Accept(comment("Hadoop QA", "-1 overall")))
+	assert.False(t, filter.Accept(comment("Marton Elek", "+1 overall")))
+
+	// other component, only the issue events are included
+	issue.Fields["components"] = []interface{}{map[string]interface{}{"name": "Ozone Client"}}
+	assert.False(t, filter.Accept(comment("Marton Elek", "LGTM")))
+	change := changeEvent(&ChangeItem{BaseIssueInfo: BaseIssueInfo{IssueKey: "HDDS-1"}, Field: "status"})
+	assert.True(t, filter.Accept(change))
+
+	var nilFilter *FilterConfig
+	assert.True(t, nilFilter.Accept(change))
+}
+
+func TestFilterInvalidBody(t *testing.T) {
+	var filter FilterConfig
+	err := yaml.UnmarshalStrict([]byte("exclude:\n  - body: \"(\"\n"), &filter)
+	assert.NotNil(t, err)
+}
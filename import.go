@@ -0,0 +1,291 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/elek/jira-retriever/jiradata"
+	"github.com/spf13/cobra"
+)
+
+// Supported formats of the offline import.
+const (
+	importAuto = "auto"
+	// Search result of the REST api (/rest/api/2/search with expand=changelog) saved as json.
+	importJson = "json"
+	// XML export of the issue navigator (rss).
+	importXml = "xml"
+	// entities.xml of a jira backup (or the backup zip itself).
+	importEntities = "entities"
+)
+
+func init() {
+	var format string
+	var importCmd = &cobra.Command{
+		Use:   "import <pipeline> <file>...",
+		Short: "Send the issues of jira export files to the sinks of a pipeline",
+		Args:  cobra.MinimumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			config, err := LoadConfig(cmd.Flag("config").Value.String())
+			if err != nil {
+				return err
+			}
+			if _, ok := config.Pipelines[args[0]]; !ok {
+				return errors.New("Unknown pipeline: " + args[0])
+			}
+			runner, err := NewPipelineRunner(config, args[0], make(map[string]*JiraClient))
+			if err != nil {
+				return err
+			}
+			defer runner.Close()
+
+			adapters, err := runner.StorageAdapters()
+			if err != nil {
+				return err
+			}
+
+			ctx, cancel := signalContext()
+			defer cancel()
+			for _, file := range args[1:] {
+				if stopRequested(ctx) {
+					break
+				}
+				issues, err := ReadExport(file, format)
+				if err != nil {
+					return err
+				}
+				log.Print(fmt.Sprintf("%d issues are read from %s", len(issues), file))
+				for _, enriched := range issues {
+					enriched.Jira = runner.client.instance()
+				}
+				for _, adapter := range adapters {
+					err = importIssues(ctx, issues, file, adapter, runner.Selector)
+					if err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		},
+	}
+	importCmd.Flags().StringVar(&format, "format", importAuto, "Format of the files: json, xml, entities "+
+		"(auto: detected from the content)")
+	rootCmd.AddCommand(importCmd)
+}
+
+// importIssues sends the imported issues to the adapter. The last updated time of the selector is not saved, so the
+// polling of the pipeline continues from the same point.
+func importIssues(ctx context.Context, issues []*EnrichedIssue, file string, adapter Adapter, selector string) error {
+	source := &ImportSource{Issues: issues, Name: file}
+	_, err := send(ctx, source, adapter, selector, time.Time{})
+	return err
+}
+
+// ImportSource produces all the changes of the imported issues. The batches have no cursor: the exports are not
+// related to the last updated time of the polling.
+type ImportSource struct {
+	Name     string
+	Issues   []*EnrichedIssue
+	PageSize int
+	next     int
+}
+
+func (source *ImportSource) Next(ctx context.Context) (*Batch, error) {
+	pageSize := source.PageSize
+	if pageSize <= 0 {
+		pageSize = defaultPageSize
+	}
+	if source.next >= len(source.Issues) {
+		return nil, nil
+	}
+	end := source.next + pageSize
+	if end > len(source.Issues) {
+		end = len(source.Issues)
+	}
+	batch := Batch{}
+	for _, enriched := range source.Issues[source.next:end] {
+		events, _, err := issueEvents(time.Time{}, enriched)
+		if err != nil {
+			return nil, err
+		}
+		batch.Events = append(batch.Events, events...)
+	}
+	source.next = end
+	return &batch, nil
+}
+
+func (source *ImportSource) Progress() string {
+	return fmt.Sprintf("%d/%d issues are imported from %s", source.next, len(source.Issues), source.Name)
+}
+
+// ReadExport reads the issues of an export file. The issues are returned in the order of the update time.
+func ReadExport(file string, format string) ([]*EnrichedIssue, error) {
+	if strings.HasSuffix(file, ".zip") {
+		return readBackupZip(file)
+	}
+	reader, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return readExport(bufio.NewReader(reader), file, format)
+}
+
+func readExport(reader *bufio.Reader, file string, format string) ([]*EnrichedIssue, error) {
+	var err error
+	if format == importAuto || format == "" {
+		format, err = detectFormat(reader)
+		if err != nil {
+			return nil, &DecodeError{What: "Export file " + file, Err: err}
+		}
+	}
+	var issues []*EnrichedIssue
+	switch format {
+	case importJson:
+		issues, err = readJsonExport(reader, file)
+	case importXml:
+		issues, err = readXmlExport(reader, file)
+	case importEntities:
+		issues, err = readEntities(reader, file)
+	default:
+		return nil, errors.New("Unknown import format: " + format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	updated := make(map[*EnrichedIssue]time.Time)
+	for _, enriched := range issues {
+		updatedField, err := stringField(enriched.Issue, "updated")
+		if err != nil {
+			return nil, err
+		}
+		updated[enriched], err = parseTime(updatedField)
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.SliceStable(issues, func(a int, b int) bool {
+		return updated[issues[a]].Before(updated[issues[b]])
+	})
+	return issues, nil
+}
+
+// readBackupZip reads the entities.xml of a jira backup zip.
+func readBackupZip(file string) ([]*EnrichedIssue, error) {
+	archive, err := zip.OpenReader(file)
+	if err != nil {
+		return nil, &DecodeError{What: "Backup " + file, Err: err}
+	}
+	defer archive.Close()
+	for _, entry := range archive.File {
+		if path.Base(entry.Name) == "entities.xml" {
+			reader, err := entry.Open()
+			if err != nil {
+				return nil, &DecodeError{What: "Backup " + file, Err: err}
+			}
+			defer reader.Close()
+			return readExport(bufio.NewReader(reader), file+"/"+entry.Name, importEntities)
+		}
+	}
+	return nil, &DecodeError{What: "Backup " + file, Err: errors.New("entities.xml is missing")}
+}
+
+// detectFormat detects the format from the first characters (json) or the root element (xml) of the file.
+func detectFormat(reader *bufio.Reader) (string, error) {
+	head, err := reader.Peek(4096)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	trimmed := bytes.TrimSpace(bytes.TrimPrefix(head, []byte("\xef\xbb\xbf")))
+	if bytes.HasPrefix(trimmed, []byte("{")) || bytes.HasPrefix(trimmed, []byte("[")) {
+		return importJson, nil
+	}
+	decoder := xml.NewDecoder(bytes.NewReader(trimmed))
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return "", errors.New("unknown format (json, xml search export or entities.xml is expected)")
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			switch start.Name.Local {
+			case "rss":
+				return importXml, nil
+			case "entity-engine-xml":
+				return importEntities, nil
+			default:
+				return "", errors.New("unknown xml root element: " + start.Name.Local)
+			}
+		}
+	}
+}
+
+// readJsonExport reads a saved search result (or a json array of issues). The comments, worklogs and changelog are
+// taken from the issues, nothing is retrieved from jira.
+func readJsonExport(reader io.Reader, file string) ([]*EnrichedIssue, error) {
+	content, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	// the files saved on windows could start with a byte order mark (accepted by detectFormat)
+	content = bytes.TrimPrefix(content, []byte("\xef\xbb\xbf"))
+	var issues jiradata.Issues
+	if bytes.HasPrefix(bytes.TrimSpace(content), []byte("[")) {
+		err = decode(content, &issues, "Export file "+file)
+	} else {
+		var searchResults jiradata.SearchResults
+		err = decode(content, &searchResults, "Export file "+file)
+		issues = searchResults.Issues
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*EnrichedIssue, 0, len(issues))
+	for _, issue := range issues {
+		for _, field := range []string{"summary", "created", "updated"} {
+			if _, ok := issue.Fields[field].(string); !ok {
+				return nil, &DecodeError{What: "Issue " + issue.Key + " of " + file, Err: errors.New(field + " field is missing")}
+			}
+		}
+		enriched := EnrichedIssue{Issue: issue}
+		if issue.Changelog != nil {
+			enriched.Histories = issue.Changelog.Histories
+		}
+		var comments CommentPage
+		err = convert(issue.Fields["comment"], &comments, "Comments of "+issue.Key)
+		if err != nil {
+			return nil, err
+		}
+		enriched.Comments = comments.Comments
+		var worklogs jiradata.WorklogWithPagination
+		err = convert(issue.Fields["worklog"], &worklogs, "Worklogs of "+issue.Key)
+		if err != nil {
+			return nil, err
+		}
+		enriched.Worklogs = worklogs.Worklogs
+		result = append(result, &enriched)
+	}
+	return result, nil
+}
+
+// userField returns the json representation of a user as it's used in the issue fields.
+func userField(user *jiradata.User) map[string]interface{} {
+	content, _ := json.Marshal(user)
+	var field map[string]interface{}
+	json.Unmarshal(content, &field)
+	return field
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadEntities(t *testing.T) {
+	backup := `<?xml version="1.0" encoding="UTF-8"?>
+<entity-engine-xml>
+    <Project id="10000" name="Ozone" key="HDDS"/>
+    <Status id="1" name="Open"/>
+    <Issue id="10001" project="10000" number="2" summary="Second" reporter="JIRAUSER1" status="1"
+        created="2018-03-02 10:00:00.0" updated="2018-03-05 10:00:00.0"/>
+    <Issue id="10000" project="10000" number="1" summary="First" reporter="JIRAUSER1" status="1"
+        created="2018-03-01 10:00:00.0" updated="2018-03-03 10:00:00.0">
+        <description><![CDATA[Long description]]></description>
+    </Issue>
+    <ApplicationUser id="1" userKey="JIRAUSER1" lowerUserName="elek"/>
+    <User id="1" userName="elek" lowerUserName="elek" displayName="Marton Elek"/>
+    <Action id="10100" issue="10000" author="JIRAUSER1" type="comment" body="LGTM" created="2018-03-02 11:00:00.0"/>
+    <ChangeGroup id="10200" issue="10000" author="JIRAUSER1" created="2018-03-03 10:00:00.0"/>
+    <ChangeItem id="10300" group="10200" fieldtype="jira" field="status" oldvalue="1" oldstring="Open"
+        newvalue="5" newstring="Resolved"/>
+    <Worklog id="10400" issue="10001" author="JIRAUSER1" body="review" timeworked="3600"
+        created="2018-03-04 10:00:00.0" startdate="2018-03-04 09:00:00.0"/>
+    <OSPropertyEntry id="1" entityName="jira.properties" propertyKey="ignored"/>
+</entity-engine-xml>`
+
+	issues, err := readExport(bufio.NewReader(strings.NewReader(backup)), "entities.xml", importAuto)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(issues))
+
+	first := issues[0]
+	assert.Equal(t, "HDDS-1", first.Issue.Key)
+	assert.Equal(t, "Long description", first.Issue.Fields["description"])
+	assert.Equal(t, "Marton Elek", first.Comments[0].Author.DisplayName)
+	assert.Equal(t, "Resolved", first.Histories[0].Items[0].ToString)
+	assert.Equal(t, 3600, issues[1].Worklogs[0].TimeSpentSeconds)
+
+	events, _, err := issueEvents(time.Time{}, first)
+	assert.Nil(t, err)
+	// issue, change and comment
+	assert.Equal(t, 3, len(events))
+	assert.Equal(t, EventIssueCreated, events[0].Type)
+}
+
+func TestReadJsonExport(t *testing.T) {
+	issues := `[
+		{"key": "HDDS-2", "fields": {"summary": "Second", "created": "2018-03-02T10:00:00.000+0000",
+			"updated": "2018-03-05T10:00:00.000+0000"}},
+		{"key": "HDDS-1", "fields": {"summary": "First", "created": "2018-03-01T10:00:00.000+0000",
+			"updated": "2018-03-03T10:00:00.000+0000",
+			"comment": {"total": 1, "comments": [{"id": "1", "body": "LGTM", "created": "2018-03-02T11:00:00.000+0000"}]},
+			"worklog": {"total": 1, "worklogs": [{"id": "2", "timeSpentSeconds": 3600}]}},
+		 "changelog": {"total": 1, "histories": [{"id": "3", "created": "2018-03-03T10:00:00.000+0000",
+			"items": [{"field": "status", "toString": "Resolved"}]}]}}
+	]`
+	cases := []struct {
+		name    string
+		content string
+		format  string
+	}{
+		{"bare array", issues, importAuto},
+		{"search result", "\xef\xbb\xbf\n" + `{"startAt": 0, "total": 2, "issues": ` + issues + `}`, importAuto},
+		{"explicit format", `{"issues": ` + issues + `}`, importJson},
+	}
+	for _, c := range cases {
+		result, err := readExport(bufio.NewReader(strings.NewReader(c.content)), "export.json", c.format)
+		assert.Nil(t, err, c.name)
+		assert.Equal(t, 2, len(result), c.name)
+		// in the order of the update time
+		first := result[0]
+		assert.Equal(t, "HDDS-1", first.Issue.Key, c.name)
+		assert.Equal(t, "LGTM", first.Comments[0].Body, c.name)
+		assert.Equal(t, 3600, first.Worklogs[0].TimeSpentSeconds, c.name)
+		assert.Equal(t, "Resolved", first.Histories[0].Items[0].ToString, c.name)
+		assert.Equal(t, "HDDS-2", result[1].Issue.Key, c.name)
+	}
+
+	_, err := readExport(bufio.NewReader(strings.NewReader(`[{"key": "HDDS-1", "fields": {"summary": "First"}}]`)),
+		"export.json", importAuto)
+	assert.Equal(t, exitDecodeError, exitCode(err))
+}
+
+func TestReadXmlExport(t *testing.T) {
+	export := `<?xml version="1.0" encoding="UTF-8"?>
+<!-- RSS generated by JIRA -->
+<rss version="0.92">
+  <channel>
+    <title>ASF JIRA</title>
+    <item>
+      <title>[HDDS-1] First</title>
+      <key id="10000">HDDS-1</key>
+      <summary>First</summary>
+      <description>&lt;p&gt;Long description&lt;/p&gt;</description>
+      <project id="10000" key="HDDS">Ozone</project>
+      <type id="1">Bug</type>
+      <priority id="3">Major</priority>
+      <status id="5">Resolved</status>
+      <resolution id="1">Fixed</resolution>
+      <assignee username="elek">Marton Elek</assignee>
+      <reporter username="elek">Marton Elek</reporter>
+      <labels><label>newbie</label></labels>
+      <created>Thu, 1 Mar 2018 10:00:00 +0000</created>
+      <updated>Sat, 3 Mar 2018 10:00:00 +0000</updated>
+      <comments>
+        <comment id="1" author="elek" created="Fri, 2 Mar 2018 11:00:00 +0000">LGTM</comment>
+      </comments>
+    </item>
+  </channel>
+</rss>`
+
+	issues, err := readExport(bufio.NewReader(strings.NewReader(export)), "export.xml", importAuto)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(issues))
+	issue := issues[0].Issue
+	assert.Equal(t, "HDDS-1", issue.Key)
+	assert.Equal(t, "2018-03-01T10:00:00.000+0000", issue.Fields["created"])
+	assert.Equal(t, []string{"newbie"}, issue.Fields["labels"])
+	assert.Equal(t, "Fixed", issue.Fields["resolution"].(map[string]interface{})["name"])
+	assert.Equal(t, "LGTM", issues[0].Comments[0].Body)
+
+	_, err = readExport(bufio.NewReader(strings.NewReader(strings.Replace(export, "Thu, 1 Mar", "2018-03-01", 1))),
+		"export.xml", importXml)
+	assert.Equal(t, exitDecodeError, exitCode(err))
+}
+
+func TestDetectFormat(t *testing.T) {
+	cases := []struct {
+		content string
+		format  string
+	}{
+		{`{"issues": []}`, importJson},
+		{"\n  [ ]", importJson},
+		{"\xef\xbb\xbf{}", importJson},
+		{`<?xml version="1.0"?><!-- comment --><rss version="0.92"/>`, importXml},
+		{`<?xml version="1.0"?><entity-engine-xml/>`, importEntities},
+		{`<html/>`, ""},
+		{`issues`, ""},
+	}
+	for _, c := range cases {
+		format, err := detectFormat(bufio.NewReader(strings.NewReader(c.content)))
+		assert.Equal(t, c.format, format, c.content)
+		assert.Equal(t, c.format == "", err != nil, c.content)
+	}
+}
+
+func TestImportIssues(t *testing.T) {
+	content := `[{"key": "HDDS-1", "fields": {"summary": "First", "created": "2018-03-01T10:00:00.000+0000",
+		"updated": "2018-03-03T10:00:00.000+0000",
+		"comment": {"total": 1, "comments": [{"id": "1", "body": "LGTM", "created": "2018-03-02T11:00:00.000+0000"}]}}}]`
+	issues, err := readExport(bufio.NewReader(strings.NewReader(content)), "export.json", importAuto)
+	assert.Nil(t, err)
+
+	// the last updated time of the polling is not changed
+	lastUpdated := time.Date(2018, 3, 1, 10, 0, 0, 0, time.UTC)
+	adapter := &memoryAdapter{lastUpdated: lastUpdated}
+	assert.Nil(t, importIssues(context.Background(), issues, "export.json", adapter, "selector"))
+	assert.Equal(t, 2, len(adapter.events))
+	assert.Equal(t, 1, adapter.commits)
+	assert.Equal(t, lastUpdated, adapter.lastUpdated)
+}
+
+func TestImportStorageSinks(t *testing.T) {
+	rate := 0.0
+	config := Config{
+		Jira: map[string]*JiraConfig{"apache": {Url: "https://issues.apache.org/jira", Rate: &rate}},
+		Pipelines: map[string]*PipelineConfig{"ozone": {JQL: "project = HDDS", Sinks: []SinkConfig{
+			{Type: "console"},
+			{Type: "slack", Channel: "ozone", Token: SecretSource{Value: "token"}},
+		}}},
+	}
+	runner, err := NewPipelineRunner(&config, "ozone", make(map[string]*JiraClient))
+	assert.Nil(t, err)
+
+	// the history is not sent to the notification sinks
+	_, err = runner.StorageAdapters()
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "no db sink")
+}
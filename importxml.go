@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/xml"
+	"io"
+	"time"
+
+	"github.com/elek/jira-retriever/jiradata"
+)
+
+// Time format of the xml search export.
+const rssTimeFormat = "Mon, 2 Jan 2006 15:04:05 -0700"
+
+// rssItem is one issue of the xml export of the issue navigator.
+type rssItem struct {
+	Key struct {
+		ID    string `xml:"id,attr"`
+		Value string `xml:",chardata"`
+	} `xml:"key"`
+	Summary     string   `xml:"summary"`
+	Description string   `xml:"description"`
+	Project     rssValue `xml:"project"`
+	Type        rssValue `xml:"type"`
+	Priority    rssValue `xml:"priority"`
+	Status      rssValue `xml:"status"`
+	Resolution  rssValue `xml:"resolution"`
+	Assignee    rssUser  `xml:"assignee"`
+	Reporter    rssUser  `xml:"reporter"`
+	Labels      []string `xml:"labels>label"`
+	Created     string   `xml:"created"`
+	Updated     string   `xml:"updated"`
+	Comments    []struct {
+		ID      string `xml:"id,attr"`
+		Author  string `xml:"author,attr"`
+		Created string `xml:"created,attr"`
+		Body    string `xml:",chardata"`
+	} `xml:"comments>comment"`
+}
+
+type rssValue struct {
+	ID    string `xml:"id,attr"`
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+type rssUser struct {
+	Username string `xml:"username,attr"`
+	Name     string `xml:",chardata"`
+}
+
+func (user rssUser) user() *jiradata.User {
+	if user.Username == "" {
+		return nil
+	}
+	return &jiradata.User{Key: user.Username, Name: user.Username, DisplayName: user.Name}
+}
+
+// readXmlExport reads the xml export of the issue navigator. The export contains the comments but not the changelog
+// and the worklogs. The description and the comments are html.
+func readXmlExport(reader io.Reader, file string) ([]*EnrichedIssue, error) {
+	decoder := xml.NewDecoder(reader)
+	var issues []*EnrichedIssue
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return issues, nil
+		}
+		if err != nil {
+			return nil, &DecodeError{What: "Export file " + file, Err: err}
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "item" {
+			continue
+		}
+		var item rssItem
+		err = decoder.DecodeElement(&item, &start)
+		if err != nil {
+			return nil, &DecodeError{What: "Export file " + file, Err: err}
+		}
+		enriched, err := item.enriched()
+		if err != nil {
+			return nil, err
+		}
+		issues = append(issues, enriched)
+	}
+}
+
+func (item *rssItem) enriched() (*EnrichedIssue, error) {
+	created, err := parseRssTime(item.Created)
+	if err != nil {
+		return nil, err
+	}
+	updated, err := parseRssTime(item.Updated)
+	if err != nil {
+		return nil, err
+	}
+	fields := map[string]interface{}{
+		"summary":     item.Summary,
+		"description": item.Description,
+		"created":     created,
+		"updated":     updated,
+		"project":     map[string]interface{}{"id": item.Project.ID, "key": item.Project.Key, "name": item.Project.Value},
+		"issuetype":   map[string]interface{}{"id": item.Type.ID, "name": item.Type.Value},
+		"priority":    map[string]interface{}{"id": item.Priority.ID, "name": item.Priority.Value},
+		"status":      map[string]interface{}{"id": item.Status.ID, "name": item.Status.Value},
+		"labels":      item.Labels,
+	}
+	if item.Resolution.ID != "" && item.Resolution.ID != "-1" {
+		fields["resolution"] = map[string]interface{}{"id": item.Resolution.ID, "name": item.Resolution.Value}
+	}
+	if reporter := item.Reporter.user(); reporter != nil {
+		fields["reporter"] = userField(reporter)
+		// the creator is not part of the export
+		fields["creator"] = userField(reporter)
+	}
+	if assignee := item.Assignee.user(); assignee != nil {
+		fields["assignee"] = userField(assignee)
+	}
+	enriched := EnrichedIssue{Issue: &jiradata.Issue{
+		ID:     item.Key.ID,
+		Key:    item.Key.Value,
+		Fields: fields,
+	}}
+	for _, comment := range item.Comments {
+		commentCreated, err := parseRssTime(comment.Created)
+		if err != nil {
+			return nil, err
+		}
+		enriched.Comments = append(enriched.Comments, &jiradata.Comment{
+			ID:      comment.ID,
+			Author:  &jiradata.User{Key: comment.Author, Name: comment.Author, DisplayName: comment.Author},
+			Body:    comment.Body,
+			Created: commentCreated,
+			Updated: commentCreated,
+		})
+	}
+	return &enriched, nil
+}
+
+// parseRssTime converts the time of the xml export to the format of the REST api.
+func parseRssTime(value string) (string, error) {
+	parsed, err := time.Parse(rssTimeFormat, value)
+	if err != nil {
+		return "", &TimeParseError{Value: value, Err: err}
+	}
+	return parsed.Format(timeFormat), nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/elek/jira-retriever/jiradata"
+)
+
+// CommentPage is one page of the comments of an issue. It's used both by the comment field embedded to the search
+// results and by the /issue/{key}/comment endpoint.
+type CommentPage struct {
+	StartAt    int                 `json:"startAt,omitempty"`
+	MaxResults int                 `json:"maxResults,omitempty"`
+	Total      int                 `json:"total,omitempty"`
+	Comments   []*jiradata.Comment `json:"comments,omitempty"`
+}
+
+// issueComments returns all the comments of the issue. The comment page embedded to the search results is truncated
+// by jira, in this case the remaining comments are retrieved from the comment endpoint of the issue.
+func issueComments(ctx context.Context, client *JiraClient, issue *jiradata.Issue) ([]*jiradata.Comment, error) {
+	var embedded CommentPage
+	err := convert(issue.Fields["comment"], &embedded, "Comments of "+issue.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	comments := embedded.Comments
+	if embedded.Total <= len(comments) {
+		return comments, nil
+	}
+	log.Printf("%s has %d comments but only %d are embedded, retrieving the remaining ones",
+		issue.Key, embedded.Total, len(comments))
+
+	seen := make(map[string]bool)
+	for _, comment := range comments {
+		seen[comment.ID] = true
+	}
+	startAt := len(comments)
+	for startAt < embedded.Total {
+		parameters := url.Values{
+			"startAt": []string{strconv.Itoa(startAt)},
+			"orderBy": []string{"created"},
+		}
+		if client.PageSize > 0 {
+			parameters.Set("maxResults", strconv.Itoa(client.PageSize))
+		}
+		content, err := client.queryWithParameters(ctx, "/issue/"+issue.Key+"/comment", parameters)
+		if err != nil {
+			return nil, err
+		}
+		var page CommentPage
+		err = decode(content, &page, "Comments of "+issue.Key)
+		if err != nil {
+			return nil, err
+		}
+		if len(page.Comments) == 0 {
+			break
+		}
+		for _, comment := range page.Comments {
+			if !seen[comment.ID] {
+				seen[comment.ID] = true
+				comments = append(comments, comment)
+			}
+		}
+		next := page.StartAt + len(page.Comments)
+		// a short page is the last one, even if the total is changed since the search
+		if next <= startAt || (page.MaxResults > 0 && len(page.Comments) < page.MaxResults) {
+			break
+		}
+		startAt = next
+		if page.Total > embedded.Total {
+			embedded.Total = page.Total
+		}
+	}
+	return comments, nil
+}
+
+// ChangelogPage is one page of the /issue/{key}/changelog endpoint.
+type ChangelogPage struct {
+	StartAt    int                `json:"startAt,omitempty"`
+	MaxResults int                `json:"maxResults,omitempty"`
+	Total      int                `json:"total,omitempty"`
+	IsLast     bool               `json:"isLast,omitempty"`
+	Values     jiradata.Histories `json:"values,omitempty"`
+}
+
+// issueHistories returns all the change histories of the issue. The changelog expanded in the search results is
+// capped by jira. If it's truncated, all the histories are retrieved from the changelog endpoint of the issue.
+func issueHistories(ctx context.Context, client *JiraClient, issue *jiradata.Issue) (jiradata.Histories, error) {
+	if issue.Changelog == nil {
+		return jiradata.Histories{}, nil
+	}
+	histories := issue.Changelog.Histories
+	if issue.Changelog.Total <= len(histories) {
+		return histories, nil
+	}
+	log.Printf("%s has %d histories but only %d are embedded, retrieving the full changelog",
+		issue.Key, issue.Changelog.Total, len(histories))
+
+	seen := make(map[string]bool)
+	for _, history := range histories {
+		seen[history.ID] = true
+	}
+	startAt := 0
+	for {
+		parameters := url.Values{
+			"startAt": []string{strconv.Itoa(startAt)},
+		}
+		if client.PageSize > 0 {
+			parameters.Set("maxResults", strconv.Itoa(client.PageSize))
+		}
+		content, err := client.queryWithParameters(ctx, "/issue/"+issue.Key+"/changelog", parameters)
+		if httpError, ok := err.(*HTTPError); ok && httpError.StatusCode == http.StatusNotFound {
+			log.Printf("Changelog endpoint is not available, only the embedded changelog of %s is used", issue.Key)
+			return histories, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		var page ChangelogPage
+		err = decode(content, &page, "Changelog of "+issue.Key)
+		if err != nil {
+			return nil, err
+		}
+		for _, history := range page.Values {
+			if !seen[history.ID] {
+				seen[history.ID] = true
+				histories = append(histories, history)
+			}
+		}
+		next := page.StartAt + len(page.Values)
+		if page.IsLast || len(page.Values) == 0 || next >= page.Total || next <= startAt ||
+			(page.MaxResults > 0 && len(page.Values) < page.MaxResults) {
+			break
+		}
+		startAt = next
+	}
+	return histories, nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/elek/jira-retriever/jiradata"
+	"github.com/stretchr/testify/assert"
+)
+
+func commentIds(comments []*jiradata.Comment) []string {
+	ids := []string{}
+	for _, comment := range comments {
+		ids = append(ids, comment.ID)
+	}
+	return ids
+}
+
+func historyIds(histories jiradata.Histories) []string {
+	ids := []string{}
+	for _, history := range histories {
+		ids = append(ids, history.ID)
+	}
+	return ids
+}
+
+func TestIssueComments(t *testing.T) {
+	cases := []struct {
+		name     string
+		embedded string
+		// the pages of the comment endpoint by startAt
+		pages  map[int]string
+		starts []int
+		ids    []string
+	}{
+		{
+			name:     "not truncated",
+			embedded: `{"total": 2, "comments": [{"id": "1"}, {"id": "2"}]}`,
+			ids:      []string{"1", "2"},
+		},
+		{
+			name:     "truncated",
+			embedded: `{"total": 5, "comments": [{"id": "1"}, {"id": "2"}]}`,
+			pages: map[int]string{
+				2: `{"startAt": 2, "maxResults": 2, "total": 5, "comments": [{"id": "3"}, {"id": "4"}]}`,
+				4: `{"startAt": 4, "maxResults": 2, "total": 5, "comments": [{"id": "5"}]}`,
+			},
+			starts: []int{2, 4},
+			ids:    []string{"1", "2", "3", "4", "5"},
+		},
+		{
+			name:     "comments are added during the paging",
+			embedded: `{"total": 3, "comments": [{"id": "1"}]}`,
+			pages: map[int]string{
+				1: `{"startAt": 1, "maxResults": 2, "total": 4, "comments": [{"id": "2"}, {"id": "3"}]}`,
+				3: `{"startAt": 3, "maxResults": 2, "total": 4, "comments": [{"id": "4"}]}`,
+			},
+			starts: []int{1, 3},
+			ids:    []string{"1", "2", "3", "4"},
+		},
+		{
+			name:     "empty page",
+			embedded: `{"total": 5, "comments": [{"id": "1"}]}`,
+			pages:    map[int]string{1: `{"startAt": 1, "maxResults": 50, "total": 1}`},
+			starts:   []int{1},
+			ids:      []string{"1"},
+		},
+		{
+			name:     "short page",
+			embedded: `{"total": 5, "comments": [{"id": "1"}]}`,
+			pages:    map[int]string{1: `{"startAt": 1, "maxResults": 50, "total": 5, "comments": [{"id": "2"}]}`},
+			starts:   []int{1},
+			ids:      []string{"1", "2"},
+		},
+	}
+	for _, c := range cases {
+		var starts []int
+		client := fakeClient(func(req *http.Request) (int, string) {
+			startAt, _ := strconv.Atoi(req.URL.Query().Get("startAt"))
+			starts = append(starts, startAt)
+			return http.StatusOK, c.pages[startAt]
+		})
+		var embedded interface{}
+		assert.Nil(t, decode([]byte(c.embedded), &embedded, c.name))
+		issue := &jiradata.Issue{Key: "HDDS-1", Fields: map[string]interface{}{"comment": embedded}}
+
+		comments, err := issueComments(context.Background(), client, issue)
+		assert.Nil(t, err, c.name)
+		assert.Equal(t, c.ids, commentIds(comments), c.name)
+		assert.Equal(t, c.starts, starts, c.name)
+	}
+}
+
+func TestIssueHistories(t *testing.T) {
+	cases := []struct {
+		name     string
+		embedded *jiradata.Changelog
+		// the pages of the changelog endpoint by startAt (missing page: HTTP 404)
+		pages  map[int]string
+		starts []int
+		ids    []string
+	}{
+		{
+			name: "no changelog",
+			ids:  []string{},
+		},
+		{
+			name:     "not truncated",
+			embedded: &jiradata.Changelog{Total: 2, Histories: jiradata.Histories{{ID: "1"}, {ID: "2"}}},
+			ids:      []string{"1", "2"},
+		},
+		{
+			name:     "truncated",
+			embedded: &jiradata.Changelog{Total: 5, Histories: jiradata.Histories{{ID: "4"}, {ID: "5"}}},
+			pages: map[int]string{
+				0: `{"startAt": 0, "maxResults": 3, "total": 5, "values": [{"id": "1"}, {"id": "2"}, {"id": "3"}]}`,
+				3: `{"startAt": 3, "maxResults": 3, "total": 5, "isLast": true, "values": [{"id": "4"}, {"id": "5"}]}`,
+			},
+			starts: []int{0, 3},
+			ids:    []string{"4", "5", "1", "2", "3"},
+		},
+		{
+			name:     "empty page",
+			embedded: &jiradata.Changelog{Total: 5, Histories: jiradata.Histories{{ID: "5"}}},
+			pages:    map[int]string{0: `{"startAt": 0, "maxResults": 3, "total": 5}`},
+			starts:   []int{0},
+			ids:      []string{"5"},
+		},
+		{
+			name:     "short page",
+			embedded: &jiradata.Changelog{Total: 5, Histories: jiradata.Histories{{ID: "5"}}},
+			pages:    map[int]string{0: `{"startAt": 0, "maxResults": 3, "total": 5, "values": [{"id": "1"}]}`},
+			starts:   []int{0},
+			ids:      []string{"5", "1"},
+		},
+		{
+			name:     "changelog endpoint is not available",
+			embedded: &jiradata.Changelog{Total: 5, Histories: jiradata.Histories{{ID: "5"}}},
+			starts:   []int{0},
+			ids:      []string{"5"},
+		},
+	}
+	for _, c := range cases {
+		var starts []int
+		client := fakeClient(func(req *http.Request) (int, string) {
+			startAt, _ := strconv.Atoi(req.URL.Query().Get("startAt"))
+			starts = append(starts, startAt)
+			page, ok := c.pages[startAt]
+			if !ok {
+				return http.StatusNotFound, ""
+			}
+			return http.StatusOK, page
+		})
+		issue := &jiradata.Issue{Key: "HDDS-1", Changelog: c.embedded}
+
+		histories, err := issueHistories(context.Background(), client, issue)
+		assert.Nil(t, err, c.name)
+		assert.Equal(t, c.ids, historyIds(histories), c.name)
+		assert.Equal(t, c.starts, starts, c.name)
+	}
+}
@@ -1,72 +1,245 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"net"
 	"time"
+	"encoding/json"
 	"net/url"
 	"net/http"
-	"strconv"
+	"io"
 	"io/ioutil"
+	"log"
+	"os"
+	"sync"
 	"github.com/spf13/cobra"
 )
 
+const defaultTimeout = time.Minute
+
 type JiraClient struct {
-	Url          string
-	Username     string
-	Password     string
-	RateLimit    int
-	JQL          string
-	Since        string
-	lastJiraCall time.Time
+	Url           string
+	Username      string
+	Password      string
+	RateLimit     float64
+	Burst         int
+	PageSize      int
+	Workers       int
+	AttachmentDir string
+	JQL           string
+	Since         string
+	Retry         RetryPolicy
+	Timeout       time.Duration
+	Auth          string
+	Token         string
+	Instance      JiraInstance
+	authProvider  AuthProvider
+	httpClient    *http.Client
 }
 
-func (jiraConfig *JiraClient) query(query string) []byte {
-	return jiraConfig.queryWithParameters(query, make(map[string][]string))
+func (jiraConfig *JiraClient) query(ctx context.Context, query string) ([]byte, error) {
+	return jiraConfig.queryWithParameters(ctx, query, make(map[string][]string))
 }
 
-func FromFlags(cmd *cobra.Command) JiraClient {
+// FromFlags creates the jira client from the command line flags. The secrets are resolved from the flags, the
+// secret files, the credential helpers, the environment variables and the netrc file (see SecretSource).
+func FromFlags(cmd *cobra.Command) (JiraClient, error) {
+	var err error
 
 	jira := JiraClient{
-		Url:       cmd.Flag("jurl").Value.String(),
-		Username:  cmd.Flag("jusername").Value.String(),
-		Password:  cmd.Flag("jpassword").Value.String(),
-		JQL:       cmd.Flag("jql").Value.String(),
-		RateLimit: 10,
+		Url:      cmd.Flag("jurl").Value.String(),
+		Username: cmd.Flag("jusername").Value.String(),
+		JQL:      cmd.Flag("jql").Value.String(),
+		Instance: JiraInstance{BrowseUrl: cmd.Flag("jbrowse").Value.String()},
+	}
+	jira.Password, err = resolveSecretFlag(cmd, "jpassword", "JIRA_PASSWORD")
+	if err != nil {
+		return jira, err
+	}
+	jira.Token, err = resolveSecretFlag(cmd, "jtoken", "JIRA_TOKEN")
+	if err != nil {
+		return jira, err
 	}
+	jira.defaultCredentials(true)
+	jira.RateLimit, _ = cmd.Flags().GetFloat64("jrate")
+	jira.Burst, _ = cmd.Flags().GetInt("jburst")
+	jira.PageSize, _ = cmd.Flags().GetInt("jpagesize")
+	jira.Workers, _ = cmd.Flags().GetInt("jworkers")
+	jira.Retry = defaultRetryPolicy
+	jira.Retry.MaxAttempts, _ = cmd.Flags().GetInt("jretries")
+	jira.Timeout, _ = cmd.Flags().GetDuration("jtimeout")
+	jira.Auth = cmd.Flag("jauth").Value.String()
 	jira.Since = cmd.Flag("since").Value.String()
-	return jira
+	jira.AttachmentDir = cmd.Flag("attachments").Value.String()
+	return jira, nil
 }
-func (jiraConfig *JiraClient) queryWithParameters(query string, parameters url.Values) []byte {
+// instance returns the jira instance of the events retrieved by the client.
+func (jiraConfig *JiraClient) instance() *JiraInstance {
+	if jiraConfig == nil {
+		return nil
+	}
+	instance := jiraConfig.Instance
+	instance.Url = jiraConfig.Url
+	return &instance
+}
+
+// defaultCredentials fills the missing username from the environment variable (if env is set) and the missing password
+// from the netrc file.
+func (jira *JiraClient) defaultCredentials(env bool) {
+	if jira.Username == "" && env {
+		jira.Username = os.Getenv("JIRA_USERNAME")
+	}
+	if jira.Password == "" && jira.Token == "" {
+		login, password := netrcCredentials(jira.Url)
+		if jira.Username == "" || jira.Username == login {
+			jira.Username = login
+			jira.Password = password
+		}
+	}
+}
+
+func (jiraConfig *JiraClient) queryWithParameters(ctx context.Context, query string, parameters url.Values) ([]byte, error) {
+	return jiraConfig.call(ctx, "GET", query, parameters, nil)
+}
+
+// post sends the json representation of the content to the jira REST api.
+func (jiraConfig *JiraClient) post(ctx context.Context, query string, content interface{}) ([]byte, error) {
+	body, err := json.Marshal(content)
+	if err != nil {
+		return nil, err
+	}
+	return jiraConfig.call(ctx, "POST", query, url.Values{}, body)
+}
+
+func (jiraConfig *JiraClient) call(ctx context.Context, method string, query string, parameters url.Values, requestBody []byte) ([]byte, error) {
 	jiraBaseUrl := jiraConfig.Url
 	jiraUrl := jiraBaseUrl + "/rest/api/2" + query
 
-	//throttle the queries
-	duration := time.Since(jiraConfig.lastJiraCall)
-	if duration.Seconds() < float64(jiraConfig.RateLimit) {
-		time.Sleep(time.Duration(jiraConfig.RateLimit)*time.Second - duration)
+	if len(parameters) > 0 {
+		jiraUrl += "?" + parameters.Encode()
 	}
 
-	jiraUrl += "?" + parameters.Encode()
-
-	client := http.Client{}
-	println("Calling jira REST api " + jiraUrl)
-	req, err := http.NewRequest("GET", jiraUrl, nil)
-	jiraConfig.lastJiraCall = time.Now()
-	client.Do(req)
-	if jiraConfig.Username != "username" {
-		req.SetBasicAuth(jiraConfig.Username, jiraConfig.Password)
+	response, err := jiraConfig.open(ctx, method, jiraUrl, requestBody)
+	if err != nil {
+		return nil, err
 	}
-	response, err := client.Do(req)
+	defer response.Body.Close()
+	body, err := ioutil.ReadAll(response.Body)
 	if err != nil {
-		panic("Jira url couldn't be opened " + err.Error())
+		return nil, &RequestError{Method: method, Url: jiraUrl, Err: err}
 	}
+	return body, nil
+}
 
-	defer response.Body.Close()
+// download opens an absolute jira url (eg. attachment content) with the same authentication as the REST calls.
+// The body of the response should be closed by the caller.
+func (jiraConfig *JiraClient) download(ctx context.Context, contentUrl string) (io.ReadCloser, error) {
+	response, err := jiraConfig.open(ctx, "GET", contentUrl, nil)
 	if err != nil {
-		panic("Can' read body " + err.Error())
+		return nil, err
 	}
-	if response.StatusCode > 400 {
-		panic("Jira API is responded with error: HTTP " + strconv.Itoa(response.StatusCode))
+	return response.Body, nil
+}
+
+// Guards the lazy initialization of the http clients, the jira calls of one client are sent from multiple goroutines.
+var httpClientMutex sync.Mutex
+
+// client returns the http client of the jira calls. Transient errors are retried according to the retry policy and
+// all the attempts are throttled by the rate limiter shared by the clients of the same jira host. The timeout is
+// applied to every attempt (waiting for the response headers), the body of big downloads could be read longer.
+func (jiraConfig *JiraClient) client() *http.Client {
+	httpClientMutex.Lock()
+	defer httpClientMutex.Unlock()
+	if jiraConfig.httpClient == nil {
+		policy := jiraConfig.Retry
+		if policy.MaxAttempts <= 0 {
+			policy.MaxAttempts = 1
+		}
+		if policy.BaseDelay <= 0 {
+			policy.BaseDelay = defaultRetryPolicy.BaseDelay
+		}
+		if policy.MaxDelay <= 0 {
+			policy.MaxDelay = defaultRetryPolicy.MaxDelay
+		}
+		timeout := jiraConfig.Timeout
+		if timeout <= 0 {
+			timeout = defaultTimeout
+		}
+		var transport http.RoundTripper = &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: (&net.Dialer{
+				Timeout:   30 * time.Second,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			MaxIdleConns:          100,
+			IdleConnTimeout:       90 * time.Second,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ResponseHeaderTimeout: timeout,
+			ExpectContinueTimeout: 1 * time.Second,
+		}
+		if jiraConfig.RateLimit > 0 {
+			host := jiraConfig.Url
+			if parsed, err := url.Parse(jiraConfig.Url); err == nil {
+				host = parsed.Host
+			}
+			limiter := sharedRateLimiter(host, jiraConfig.RateLimit, jiraConfig.Burst)
+			transport = &rateLimitedTransport{base: transport, limiter: limiter}
+		}
+		jiraConfig.httpClient = &http.Client{
+			Transport: &retryTransport{base: transport, policy: policy},
+		}
 	}
-	body, err := ioutil.ReadAll(response.Body)
-	return body
+	return jiraConfig.httpClient
+}
+
+// authentication returns the authentication provider of the client.
+func (jiraConfig *JiraClient) authentication() (AuthProvider, error) {
+	httpClientMutex.Lock()
+	defer httpClientMutex.Unlock()
+	if jiraConfig.authProvider == nil {
+		provider, err := newAuthProvider(jiraConfig)
+		if err != nil {
+			return nil, err
+		}
+		jiraConfig.authProvider = provider
+	}
+	return jiraConfig.authProvider, nil
+}
+
+func (jiraConfig *JiraClient) open(ctx context.Context, method string, jiraUrl string, requestBody []byte) (*http.Response, error) {
+	auth, err := jiraConfig.authentication()
+	if err != nil {
+		return nil, err
+	}
+	log.Print("Calling jira REST api " + jiraUrl)
+	var response *http.Response
+	for reauthenticated := false; ; reauthenticated = true {
+		req, err := http.NewRequest(method, jiraUrl, bytes.NewReader(requestBody))
+		if err != nil {
+			return nil, &RequestError{Method: method, Url: jiraUrl, Err: err}
+		}
+		req = req.WithContext(ctx)
+		if requestBody != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		err = auth.Authenticate(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		response, err = jiraConfig.client().Do(req)
+		if err != nil {
+			return nil, &RequestError{Method: method, Url: jiraUrl, Err: err}
+		}
+		if response.StatusCode != http.StatusUnauthorized || reauthenticated || !auth.Invalidate(req) {
+			break
+		}
+		response.Body.Close()
+	}
+	if response.StatusCode >= 400 {
+		defer response.Body.Close()
+		body, _ := ioutil.ReadAll(response.Body)
+		return nil, newHTTPError(method, jiraUrl, response.StatusCode, body)
+	}
+	return response, nil
 }
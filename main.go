@@ -1,9 +1,10 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
+	"os"
 	_ "github.com/lib/pq"
-	"net/url"
 	"time"
 	"strconv"
 	"github.com/spf13/cobra"
@@ -54,10 +55,23 @@ type CommentItem struct {
 	BaseIssueInfo
 	Comment jiradata.Comment
 }
+type WorklogItem struct {
+	BaseIssueInfo
+	Worklog jiradata.Worklog
+}
+
+type AttachmentItem struct {
+	BaseIssueInfo
+	Attachment jiradata.Attachment
+	Stored     StoredAttachment
+}
+
 type ChangeItem struct {
 	BaseIssueInfo
 	HistoryId    int
 	ItemIndex    int
+	From         string
+	To           string
 	FromString   string
 	ToString     string
 	AuthorKey    string
@@ -65,37 +79,61 @@ type ChangeItem struct {
 	Field        string
 }
 
+// displayName returns the display name of the user or empty string if the user is not known.
+func displayName(user *jiradata.User) string {
+	if user == nil {
+		return ""
+	}
+	return user.DisplayName
+}
+
 var timeFormat = "2006-01-02T15:04:05.000-0700"
 
 
-func JiraFromJson(data jiradata.Issue) JiraItem {
-	created, err := time.Parse(timeFormat, data.Fields["created"].(string))
+// userKey returns the key of the user or empty string if the user is not known.
+func userKey(user *jiradata.User) string {
+	if user == nil {
+		return ""
+	}
+	return user.Key
+}
+
+func JiraFromJson(data jiradata.Issue) (JiraItem, error) {
+	createdField, err := stringField(&data, "created")
 	if err != nil {
-		panic(err)
+		return JiraItem{}, err
+	}
+	created, err := parseTime(createdField)
+	if err != nil {
+		return JiraItem{}, err
+	}
+	summary, err := stringField(&data, "summary")
+	if err != nil {
+		return JiraItem{}, err
 	}
 	issueRef := BaseIssueInfo{
 		Created:      created,
 		IssueKey:     data.Key,
-		IssueSummary: data.Fields["summary"].(string)}
+		IssueSummary: summary}
 	return JiraItem{
 		BaseIssueInfo: issueRef,
 		Issue:         data,
-	}
+	}, nil
 }
 
 
 type Adapter interface {
-	saveIssue(issue JiraItem, selector string) error
-	saveChange(item ChangeItem, selector string) error
-	saveComment(item CommentItem, selector string) error
+	saveEvent(ctx context.Context, event *Event, selector string) error
 
-	getLastUpdated(selector string) (time.Time, error)
-	saveLastUpdated(lastUpdated time.Time, selector string) error
+	getLastUpdated(ctx context.Context, selector string) (time.Time, error)
+	saveLastUpdated(ctx context.Context, lastUpdated time.Time, selector string) error
 
-	Commit() error
-	Begin() error
+	Commit(ctx context.Context) error
+	Begin(ctx context.Context) error
+	// Rollback discards the changes since Begin. It's called after the failures of saveEvent and Commit.
+	Rollback(ctx context.Context) error
 
-	Finish() error
+	Finish(ctx context.Context) error
 }
 
 
@@ -111,85 +149,120 @@ var rootCmd = &cobra.Command{
 func main() {
 
 	rootCmd.PersistentFlags().String("jurl", "http://localhost", "Base url for the jira API")
-	rootCmd.PersistentFlags().String("jusername", "username", "Username for the jira")
-	rootCmd.PersistentFlags().String("jpassword", "password", "Password for the jira")
+	rootCmd.PersistentFlags().String("jbrowse", "", "Base url of the issue links "+
+		"(default: the base url of the issues returned by jira)")
+	rootCmd.PersistentFlags().String("jusername", "", "Username (or email address for Jira Cloud) for the jira")
+	rootCmd.PersistentFlags().String("jpassword", "", "Password for the jira")
+	addSecretFlags(rootCmd.PersistentFlags(), "jpassword", "jira password")
+	rootCmd.PersistentFlags().String("jtoken", "", "API token (Jira Cloud) or personal access token (Data Center)")
+	addSecretFlags(rootCmd.PersistentFlags(), "jtoken", "jira token")
+	rootCmd.PersistentFlags().String("jauth", authAuto, "Authentication mode: basic, cloud (email + API token), "+
+		"bearer (personal access token), session (cookie based session), none. Default: cloud if username and token are defined, "+
+		"bearer if only token, basic if only username")
 	rootCmd.PersistentFlags().String("jql", "", "Custom JQL fragment to add to the query")
+	rootCmd.PersistentFlags().String("attachments", "", "Directory to mirror the content of the new attachments "+
+		"(disabled if empty)")
+	rootCmd.PersistentFlags().Float64("jrate", 1, "Maximum number of jira calls per second, shared by all the "+
+		"pipelines of the same jira host (0 to disable the throttling)")
+	rootCmd.PersistentFlags().Int("jburst", 5, "Number of jira calls allowed at once above the rate limit")
+	rootCmd.PersistentFlags().Int("jretries", defaultRetryPolicy.MaxAttempts, "Maximum number of attempts of "+
+		"a jira call failed with a transient error (timeout, HTTP 429/502/503/504)")
+	rootCmd.PersistentFlags().Duration("jtimeout", defaultTimeout, "Timeout of one attempt of a jira call")
+	rootCmd.PersistentFlags().Int("jworkers", defaultWorkers, "Number of issues enriched in parallel with "+
+		"the follow-up jira calls (comments, changelog, worklogs, attachments)")
+	rootCmd.PersistentFlags().Int("jpagesize", defaultPageSize, "Number of issues requested by one search call")
 	rootCmd.PersistentFlags().String("since", "last", "Define timebox to the jira quey. Could be a "+
-		"1.) unix epoch 2.) last (to check the results since the last run")
+		"1.) unix epoch 2.) last (to check the results since the last run) 3.) duration (eg. 48h)")
+	rootCmd.PersistentFlags().String("config", defaultConfigFile(), "Config file of the pipelines (used by the run command)")
 
-	rootCmd.Execute()
+	rootCmd.SilenceUsage = true
+	err := rootCmd.Execute()
+	if err != nil {
+		os.Exit(exitCode(err))
+	}
 }
 
-func process(config *JiraClient, adapter Adapter) {
-	var err error
-	var lastUpdated time.Time
+// process retrieves the changes from jira and sends them to the adapter. On graceful stop (see signalContext) the
+// current page is finished and committed and the last updated time of the processed pages is saved, so the next run
+// continues from the same point.
+func process(ctx context.Context, config *JiraClient, adapter Adapter) error {
 	selector := getHash(config.JQL)
-	epoch, err := strconv.Atoi(config.Since)
+	since, err := resolveSince(ctx, config.Since, adapter, selector)
 	if err != nil {
-		if config.Since == "" || config.Since == "last" {
-			lastUpdated, err = adapter.getLastUpdated(selector)
-			if err != nil {
-				panic(err)
-			}
-		} else {
-			panic("Unknown since value")
-		}
-
-	} else {
-		lastUpdated = time.Unix(int64(epoch), 0)
+		return err
 	}
+	log.Print(fmt.Sprintf("Checking jira changes since %s", since.Format(time.RFC3339)))
+	source, err := NewSearchSource(config, since)
+	if err != nil {
+		return err
+	}
+	return consume(ctx, source, adapter, selector, since)
+}
 
-	log.Print(fmt.Sprintf("Checking jira changes since %s", lastUpdated.Format(time.RFC3339)))
-	queryLoop := true
-	for queryLoop == true {
-
-		jsonContent := readQuery(lastUpdated, config, config.JQL)
-		if err != nil {
-			panic("Can't load the jira json from the server API call: " + err.Error())
-		}
-
-		var searchResults jiradata.SearchResults
-
-		err = json.Unmarshal(jsonContent, &searchResults)
-		if err != nil {
-			println("Json can't be parsed to typed object " + err.Error())
-		}
-
-		if len(searchResults.ErrorMessages) > 0 {
-			panic(searchResults.ErrorMessages[0])
-		}
-		if searchResults.MaxResults == 0 {
-			print("No more results")
-			return
-		}
-
-		err = adapter.Begin()
+// resolveSince returns the start time of the query. Could be a unix epoch, a duration (relative to now) or last (the
+// last updated time saved by the adapter).
+func resolveSince(ctx context.Context, since string, adapter Adapter, selector string) (time.Time, error) {
+	epoch, err := strconv.Atoi(since)
+	if err == nil {
+		return time.Unix(int64(epoch), 0), nil
+	}
+	if since == "" || since == "last" {
+		lastUpdated, err := adapter.getLastUpdated(ctx, selector)
 		if err != nil {
-			panic("Transaction couldn't been started")
+			return time.Time{}, adapterError("getLastUpdated", err)
 		}
-		for r := 0; r < len(searchResults.Issues); r++ {
-			adapter.saveIssue(JiraFromJson(*searchResults.Issues[r]), selector)
-			processHistory(lastUpdated, adapter, searchResults.Issues[r], selector);
-			processComments(lastUpdated, adapter, searchResults.Issues[r], selector);
-
-			updated, err := time.Parse(timeFormat, searchResults.Issues[r].Fields["updated"].(string))
-			if err != nil {
-				panic(err.Error())
-			}
+		return lastUpdated, nil
+	}
+	if duration, err := time.ParseDuration(since); err == nil {
+		return time.Now().Add(-duration), nil
+	}
+	return time.Time{}, errors.New("Unknown since value: " + since)
+}
 
-			if updated.After(lastUpdated) {
-				lastUpdated = updated
-			}
-		}
-		err = adapter.Commit()
-		if err != nil {
-			panic("Committing to the database was unsuccessful " + err.Error())
-		}
-		queryLoop = searchResults.MaxResults < searchResults.Total
+// issueEvents returns the events of the issue and all the changes of the issue since fromTime. It returns with the
+// update time of the issue.
+func issueEvents(fromTime time.Time, enriched *EnrichedIssue) ([]*Event, time.Time, error) {
+	issue := enriched.Issue
+	item, err := JiraFromJson(*issue)
+	if err != nil {
+		return nil, time.Time{}, err
 	}
-	adapter.saveLastUpdated(lastUpdated, selector)
-	adapter.Finish()
+	events := []*Event{issueEvent(&item, fromTime)}
+	changes, err := historyItems(fromTime, issue, enriched.Histories)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	for _, change := range changes {
+		events = append(events, changeEvent(change))
+	}
+	comments, err := commentItems(fromTime, issue, enriched.Comments)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	for _, comment := range comments {
+		events = append(events, commentEvent(comment, EventCommentAdded))
+	}
+	worklogs, err := worklogItems(fromTime, issue, enriched.Worklogs)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	for _, worklog := range worklogs {
+		events = append(events, worklogEvent(worklog))
+	}
+	attachments, err := attachmentItems(issue, enriched.Attachments, enriched.Stored)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	for _, attachment := range attachments {
+		events = append(events, attachmentEvent(attachment))
+	}
+	for _, event := range events {
+		event.Issue = issue
+		event.Jira = enriched.Jira
+	}
+	return events, item.updated(), nil
 }
+
 func getHash(input string) string {
 	h := sha1.New()
 	h.Write([]byte(input))
@@ -197,43 +270,86 @@ func getHash(input string) string {
 	return strings.Trim(fmt.Sprintf("%x\n", bs), "\n")
 }
 
-func processComments(fromTime time.Time, adapter Adapter, issue *jiradata.Issue, selector string) {
-	commentPage := issue.Fields["comment"].(map[string]interface{})
-	var comments []jiradata.Comment
-
-	marshalled, err := json.Marshal(commentPage["comments"])
+func commentItems(fromTime time.Time, issue *jiradata.Issue, comments []*jiradata.Comment) ([]*CommentItem, error) {
+	summary, err := stringField(issue, "summary")
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-
-	err = json.Unmarshal(marshalled, &comments)
-	if err != nil {
-		panic(err)
-	}
-
+	var items []*CommentItem
 	for _, comment := range comments {
-		created, err := time.Parse(timeFormat, comment.Created)
+		created, err := parseTime(comment.Created)
 		if err != nil {
-			panic(err.Error())
+			return nil, err
 		}
 		if fromTime.Before(created) {
-			adapter.saveComment(CommentItem{
+			items = append(items, &CommentItem{
 				BaseIssueInfo: BaseIssueInfo{
 					IssueKey:     issue.Key,
-					IssueSummary: issue.Fields["summary"].(string),
+					IssueSummary: summary,
 					Created:      created,
 				},
-				Comment: comment,
-			}, selector)
+				Comment: *comment,
+			})
+		}
+	}
+	return items, nil
+}
+
+func worklogItems(fromTime time.Time, issue *jiradata.Issue, worklogs jiradata.Worklogs) ([]*WorklogItem, error) {
+	summary, err := stringField(issue, "summary")
+	if err != nil {
+		return nil, err
+	}
+	var items []*WorklogItem
+	for _, worklog := range worklogs {
+		updated, err := parseTime(worklog.Updated)
+		if err != nil {
+			return nil, err
+		}
+		if fromTime.Before(updated) {
+			items = append(items, &WorklogItem{
+				BaseIssueInfo: BaseIssueInfo{
+					IssueKey:     issue.Key,
+					IssueSummary: summary,
+					Created:      updated,
+				},
+				Worklog: *worklog,
+			})
 		}
 	}
+	return items, nil
 }
 
-func processHistory(fromTime time.Time, adapter Adapter, issue *jiradata.Issue, selector string) {
-	for _, history := range issue.Changelog.Histories {
-		created, err := time.Parse(timeFormat, history.Created)
+func attachmentItems(issue *jiradata.Issue, attachments []*jiradata.Attachment, stored []StoredAttachment) ([]*AttachmentItem, error) {
+	summary, err := stringField(issue, "summary")
+	if err != nil {
+		return nil, err
+	}
+	var items []*AttachmentItem
+	for idx, attachment := range attachments {
+		items = append(items, &AttachmentItem{
+			BaseIssueInfo: BaseIssueInfo{
+				IssueKey:     issue.Key,
+				IssueSummary: summary,
+				Created:      stored[idx].Created,
+			},
+			Attachment: *attachment,
+			Stored:     stored[idx],
+		})
+	}
+	return items, nil
+}
+
+func historyItems(fromTime time.Time, issue *jiradata.Issue, histories jiradata.Histories) ([]*ChangeItem, error) {
+	summary, err := stringField(issue, "summary")
+	if err != nil {
+		return nil, err
+	}
+	var items []*ChangeItem
+	for _, history := range histories {
+		created, err := parseTime(history.Created)
 		if err != nil {
-			panic(err.Error())
+			return nil, err
 		}
 		if created.After(fromTime) {
 
@@ -241,42 +357,27 @@ func processHistory(fromTime time.Time, adapter Adapter, issue *jiradata.Issue,
 
 				historyId, err := strconv.Atoi(history.ID)
 				if err != nil {
-					panic(err)
+					return nil, &DecodeError{What: "History id of " + issue.Key, Err: err}
 				}
-				changeItem := ChangeItem{
+				items = append(items, &ChangeItem{
 					BaseIssueInfo: BaseIssueInfo{
 						IssueKey:     issue.Key,
-						IssueSummary: issue.Fields["summary"].(string),
+						IssueSummary: summary,
 						Created:      created,
 					},
-					AuthorKey:  history.Author.Key,
-					AuthorName: history.Author.DisplayName,
+					AuthorKey:  userKey(history.Author),
+					AuthorName: displayName(history.Author),
 					HistoryId:  historyId,
+					From:       item.From,
+					To:         item.To,
 					FromString: item.FromString,
 					ToString:   item.ToString,
 					Field:      item.Field,
 					ItemIndex:  idx,
-				}
-				err = adapter.saveChange(changeItem, selector)
-				if err != nil {
-					panic(err.Error())
-				}
+				})
 			}
 
 		}
 	}
-}
-
-func readQuery(lastUpdated time.Time, jiraConfig *JiraClient, queryFragment string) []byte {
-	sinceMs := lastUpdated.UnixNano() / 1000000
-	if sinceMs < 0 {
-		sinceMs = 0
-	}
-	query := "updated > " + strconv.FormatInt(sinceMs, 10) + " ORDER BY updated ASC"
-	if len(queryFragment) > 0 {
-		query = "(" + queryFragment + ") AND " + query
-	}
-	parameter := url.Values{"jql": []string{query}, "expand": []string{"changelog,comments"}, "fields": []string{"*all"}}
-
-	return jiraConfig.queryWithParameters("/search", parameter)
+	return items, nil
 }
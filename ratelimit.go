@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// RateLimiter is a token bucket: tokens are added with a constant rate up to the burst size and every request
+// consumes one token.
+type RateLimiter struct {
+	mutex  sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	// now returns the current time (replaced by the tests)
+	now func() time.Time
+}
+
+// NewRateLimiter creates a rate limiter which allows rate requests per second on average and burst requests at once.
+// The limiter is disabled if the rate is not positive.
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+		now:    time.Now,
+	}
+}
+
+// reserve takes one token and returns how long the caller should wait before using it.
+func (limiter *RateLimiter) reserve() time.Duration {
+	if limiter.rate <= 0 {
+		return 0
+	}
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	now := limiter.now()
+	limiter.tokens += now.Sub(limiter.last).Seconds() * limiter.rate
+	if limiter.tokens > limiter.burst {
+		limiter.tokens = limiter.burst
+	}
+	limiter.last = now
+	limiter.tokens--
+	if limiter.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-limiter.tokens / limiter.rate * float64(time.Second))
+}
+
+// cancel gives back the token of a canceled request.
+func (limiter *RateLimiter) cancel() {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	limiter.tokens++
+}
+
+// Wait blocks until the next request is allowed or the context is canceled.
+func (limiter *RateLimiter) Wait(ctx context.Context) error {
+	wait := limiter.reserve()
+	if wait > 0 {
+		log.Printf("Jira calls are throttled, waiting %s", wait)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			limiter.cancel()
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+var rateLimiters = struct {
+	sync.Mutex
+	byHost map[string]*RateLimiter
+}{byHost: make(map[string]*RateLimiter)}
+
+// sharedRateLimiter returns the rate limiter of the jira host. All the clients of the same host in the process use
+// the same limiter, the settings of the first client are used.
+func sharedRateLimiter(host string, rate float64, burst int) *RateLimiter {
+	rateLimiters.Lock()
+	defer rateLimiters.Unlock()
+	limiter, found := rateLimiters.byHost[host]
+	if !found {
+		limiter = NewRateLimiter(rate, burst)
+		rateLimiters.byHost[host] = limiter
+	} else if limiter.rate != rate || limiter.burst != float64(burst) {
+		log.Printf("Rate limit of %s is already defined, using %.2f request/sec (burst %d)", host, limiter.rate,
+			int(limiter.burst))
+	}
+	return limiter
+}
+
+// rateLimitedTransport is a http.RoundTripper which waits for the rate limiter before every request.
+type rateLimitedTransport struct {
+	base    http.RoundTripper
+	limiter *RateLimiter
+}
+
+func (transport *rateLimitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	err := transport.limiter.Wait(req.Context())
+	if err != nil {
+		return nil, err
+	}
+	return transport.base.RoundTrip(req)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeClock is a manually advanced clock of the rate limiter.
+type fakeClock struct {
+	current time.Time
+}
+
+func (clock *fakeClock) now() time.Time {
+	return clock.current
+}
+
+func (clock *fakeClock) advance(duration time.Duration) {
+	clock.current = clock.current.Add(duration)
+}
+
+func newTestRateLimiter(rate float64, burst int) (*RateLimiter, *fakeClock) {
+	clock := &fakeClock{current: time.Date(2018, 3, 1, 10, 0, 0, 0, time.UTC)}
+	limiter := NewRateLimiter(rate, burst)
+	limiter.now = clock.now
+	limiter.last = clock.now()
+	return limiter, clock
+}
+
+func TestRateLimiterBurst(t *testing.T) {
+	limiter, _ := newTestRateLimiter(2, 3)
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, time.Duration(0), limiter.reserve())
+	}
+	assert.Equal(t, 500*time.Millisecond, limiter.reserve())
+	assert.Equal(t, time.Second, limiter.reserve())
+}
+
+func TestRateLimiterRefill(t *testing.T) {
+	limiter, clock := newTestRateLimiter(2, 3)
+	for i := 0; i < 3; i++ {
+		limiter.reserve()
+	}
+	clock.advance(time.Second)
+	assert.Equal(t, time.Duration(0), limiter.reserve())
+	assert.Equal(t, time.Duration(0), limiter.reserve())
+	assert.Equal(t, 500*time.Millisecond, limiter.reserve())
+
+	// the tokens are not collected over the burst size
+	clock.advance(time.Hour)
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, time.Duration(0), limiter.reserve())
+	}
+	assert.Equal(t, 500*time.Millisecond, limiter.reserve())
+}
+
+func TestRateLimiterDisabled(t *testing.T) {
+	limiter, _ := newTestRateLimiter(0, 1)
+	for i := 0; i < 100; i++ {
+		assert.Equal(t, time.Duration(0), limiter.reserve())
+	}
+	assert.Nil(t, limiter.Wait(context.Background()))
+}
+
+func TestRateLimiterCancel(t *testing.T) {
+	limiter, clock := newTestRateLimiter(0.001, 1)
+	assert.Nil(t, limiter.Wait(context.Background()))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, context.Canceled, limiter.Wait(ctx))
+
+	// the token of the canceled request is given back
+	clock.advance(1000 * time.Second)
+	assert.Equal(t, time.Duration(0), limiter.reserve())
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"text/template"
+)
+
+// Built-in templates of the console adapter. The event templates are looked up by the event type (eg. issue.resolved)
+// and then by the kind of the event (issue, change, comment, worklog, attachment).
+const consoleTemplates = `
+{{- define "header"}}
+
+{{with .Jira}}{{with .DisplayName}}{{.}} {{end}}{{end}}[{{.IssueKey}}] {{.IssueSummary}}
+
+{{end -}}
+
+{{- define "issue"}}   {{.Timestamp.Format "2006-01-02 15:04"}} -- CREATED by {{.Actor.DisplayName}}
+
+{{with .Payload.Issue.Fields.description}}{{.}}
+
+{{end}}{{end -}}
+
+{{- define "change"}}   {{.Timestamp.Format "2006-01-02 15:04"}} -- {{.Payload.Field}}: {{with .Payload.FromString}}{{.}} --> {{end}}{{.Payload.ToString}} ({{.Payload.AuthorName}})
+
+{{end -}}
+
+{{- define "comment"}}   {{.Timestamp.Format "2006-01-02 15:04"}} -- {{.Title}} ({{.Actor.DisplayName}})
+
+{{.Payload.Comment.Body | replace "\n" "\n\n    " | printf "    %s"}}
+
+{{end -}}
+
+{{- define "worklog"}}   {{.Timestamp.Format "2006-01-02 15:04"}} -- Worklog {{.Payload.Worklog.TimeSpent}} ({{.Actor.DisplayName}})
+
+{{with .Payload.Worklog.Comment}}{{. | replace "\n" "\n    " | printf "    %s"}}
+
+{{end}}{{end -}}
+
+{{- define "attachment"}}   {{.Timestamp.Format "2006-01-02 15:04"}} -- Attachment {{.Payload.Stored.Filename}}, {{.Payload.Stored.Size}} bytes ({{.Payload.Stored.Author}})
+    {{.Payload.Stored.Path}}
+
+{{end -}}
+`
+
+// Built-in templates of the slack adapter. The header is the text of the message, the event templates are the text
+// of the message attachments and the <name>.title templates are the titles of the attachments.
+const slackTemplates = `
+{{- define "header"}}{{with .Jira}}{{with .Icon}}{{.}} {{end}}{{with .DisplayName}}{{.}} {{end}}{{end -}}
+{{with .BrowseUrl}}<{{.}}|{{$.IssueKey}}>{{else}}{{.IssueKey}}{{end}} *{{.IssueSummary}}*{{end -}}
+
+{{- define "issue.title"}}{{.Title}}{{end -}}
+{{- define "issue"}}{{with .Payload.Issue.Fields.description}}{{.}}{{end}}{{end -}}
+
+{{- define "change.title"}}{{.Title}}{{end -}}
+{{- define "change"}}{{with .Payload.FromString}}{{.}} --> {{end}} {{.Payload.ToString}}{{end -}}
+
+{{- define "comment.title"}}{{.Title}}{{end -}}
+{{- define "comment"}}{{.Payload.Comment.Body | replace "\n" "\n\n"}}{{end -}}
+
+{{- define "worklog.title"}}Work logged: {{.Payload.Worklog.TimeSpent}}{{end -}}
+{{- define "worklog"}}{{.Payload.Worklog.Comment}}{{end -}}
+
+{{- define "attachment.title"}}Attachment added: {{.Payload.Stored.Filename}}{{end -}}
+{{- define "attachment"}}{{.Payload.Stored.MimeType}}, {{.Payload.Stored.Size}} bytes{{end -}}
+`
+
+var templateFuncs = template.FuncMap{
+	// replace could be used in pipelines: {{.Payload.Comment.Body | replace "\n" "\n> "}}
+	"replace": func(old string, new string, s string) string {
+		return strings.Replace(s, old, new, -1)
+	},
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+}
+
+// Renderer renders the issue headers and the events with text/template.
+type Renderer struct {
+	templates *template.Template
+	// empty templates, text/template doesn't replace the built-in template with an empty one
+	empty map[string]bool
+}
+
+var (
+	defaultConsoleRenderer = mustRenderer(consoleTemplates)
+	defaultSlackRenderer   = mustRenderer(slackTemplates)
+)
+
+func mustRenderer(defaults string) *Renderer {
+	renderer, err := NewRenderer(defaults, nil)
+	if err != nil {
+		panic(err)
+	}
+	return renderer
+}
+
+// NewRenderer parses the built-in templates and the overrides. The overrides are template texts by the name of the
+// template (header, event type, kind of the event or <name>.title). An empty override hides the events of the template.
+func NewRenderer(defaults string, overrides map[string]string) (*Renderer, error) {
+	templates, err := template.New("defaults").Funcs(templateFuncs).Parse(defaults)
+	if err != nil {
+		return nil, err
+	}
+	empty := make(map[string]bool)
+	for name, text := range overrides {
+		if strings.TrimSpace(text) == "" {
+			empty[name] = true
+			continue
+		}
+		_, err = templates.New(name).Parse(text)
+		if err != nil {
+			return nil, errors.New("Template " + name + " is invalid: " + err.Error())
+		}
+	}
+	return &Renderer{templates: templates, empty: empty}, nil
+}
+
+// Header renders the header of the issue of the event.
+func (renderer *Renderer) Header(event *Event) (string, error) {
+	return renderer.execute("header", event)
+}
+
+// Event renders the event. It returns an empty string if there is no template for the event.
+func (renderer *Renderer) Event(event *Event) (string, error) {
+	return renderer.render(event, "")
+}
+
+// Title renders the title of the event.
+func (renderer *Renderer) Title(event *Event) (string, error) {
+	return renderer.render(event, ".title")
+}
+
+func (renderer *Renderer) render(event *Event, suffix string) (string, error) {
+	for _, name := range []string{string(event.Type), eventKind(event)} {
+		if renderer.empty[name+suffix] {
+			return "", nil
+		}
+		if renderer.templates.Lookup(name+suffix) != nil {
+			return renderer.execute(name+suffix, event)
+		}
+	}
+	return "", nil
+}
+
+func (renderer *Renderer) execute(name string, event *Event) (string, error) {
+	if renderer.empty[name] {
+		return "", nil
+	}
+	var buffer bytes.Buffer
+	err := renderer.templates.ExecuteTemplate(&buffer, name, event)
+	if err != nil {
+		return "", errors.New("Template " + name + " couldn't be rendered for " + event.ID + ": " + err.Error())
+	}
+	return buffer.String(), nil
+}
+
+// eventKind returns the kind of the payload of the event.
+func eventKind(event *Event) string {
+	switch event.Payload.(type) {
+	case *JiraItem:
+		return "issue"
+	case *ChangeItem:
+		return "change"
+	case *CommentItem:
+		return "comment"
+	case *WorklogItem:
+		return "worklog"
+	case *AttachmentItem:
+		return "attachment"
+	}
+	return ""
+}
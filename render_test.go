@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/elek/jira-retriever/jiradata"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDefaultConsoleTemplates(t *testing.T) {
+	created := time.Date(2018, 3, 1, 10, 0, 0, 0, time.UTC)
+	change := changeEvent(&ChangeItem{
+		BaseIssueInfo: BaseIssueInfo{IssueKey: "HDDS-1", IssueSummary: "Test issue", Created: created},
+		Field:         "resolution",
+		ToString:      "Fixed",
+		AuthorName:    "Marton Elek",
+	})
+
+	header, err := defaultConsoleRenderer.Header(change)
+	assert.Nil(t, err)
+	assert.Equal(t, "\n\n[HDDS-1] Test issue\n\n", header)
+
+	text, err := defaultConsoleRenderer.Event(change)
+	assert.Nil(t, err)
+	assert.Equal(t, "   2018-03-01 10:00 -- resolution: Fixed (Marton Elek)\n\n", text)
+
+	comment := commentEvent(&CommentItem{
+		BaseIssueInfo: BaseIssueInfo{IssueKey: "HDDS-1", Created: created},
+		Comment:       jiradata.Comment{ID: "1", Body: "LGTM\n+1", Author: &jiradata.User{DisplayName: "Marton Elek"}},
+	}, EventCommentAdded)
+	text, err = defaultConsoleRenderer.Event(comment)
+	assert.Nil(t, err)
+	assert.Equal(t, "   2018-03-01 10:00 -- Comment (Marton Elek)\n\n    LGTM\n\n    +1\n\n", text)
+
+	// the bots are excluded by the filters, not by the templates
+	bot := commentEvent(&CommentItem{
+		BaseIssueInfo: BaseIssueInfo{IssueKey: "HDDS-1", Created: created},
+		Comment:       jiradata.Comment{ID: "2", Body: "-1 overall", Author: &jiradata.User{DisplayName: "genericqa"}},
+	}, EventCommentAdded)
+	text, err = defaultConsoleRenderer.Event(bot)
+	assert.Nil(t, err)
+	assert.Equal(t, "   2018-03-01 10:00 -- Comment (genericqa)\n\n    -1 overall\n\n", text)
+	text, err = defaultSlackRenderer.Event(bot)
+	assert.Nil(t, err)
+	assert.Equal(t, "-1 overall", text)
+}
+
+func TestTemplateOverrides(t *testing.T) {
+	renderer, err := NewRenderer(slackTemplates, map[string]string{
+		"header":         "{{.IssueKey | lower}}",
+		"issue.resolved": "resolved by {{.Actor.DisplayName}}",
+		"comment":        "",
+	})
+	assert.Nil(t, err)
+
+	resolved := changeEvent(&ChangeItem{BaseIssueInfo: BaseIssueInfo{IssueKey: "HDDS-1"}, Field: "resolution",
+		ToString: "Fixed", AuthorName: "Marton Elek"})
+	header, err := renderer.Header(resolved)
+	assert.Nil(t, err)
+	assert.Equal(t, "hdds-1", header)
+	text, err := renderer.Event(resolved)
+	assert.Nil(t, err)
+	assert.Equal(t, "resolved by Marton Elek", text)
+	title, err := renderer.Title(resolved)
+	assert.Nil(t, err)
+	assert.Equal(t, "Resolved", title)
+
+	comment := commentEvent(&CommentItem{Comment: jiradata.Comment{ID: "1", Body: "LGTM"}}, EventCommentAdded)
+	text, err = renderer.Event(comment)
+	assert.Nil(t, err)
+	assert.Equal(t, "", text)
+
+	_, err = NewRenderer(slackTemplates, map[string]string{"header": "{{.IssueKey"})
+	assert.NotNil(t, err)
+}
+
+func TestSlackHeaderLinks(t *testing.T) {
+	event := changeEvent(&ChangeItem{BaseIssueInfo: BaseIssueInfo{IssueKey: "HDDS-1", IssueSummary: "Test issue"}})
+	header, err := defaultSlackRenderer.Header(event)
+	assert.Nil(t, err)
+	assert.Equal(t, "HDDS-1 *Test issue*", header)
+
+	event.Issue = &jiradata.Issue{Self: "https://jira.example.com/jira/rest/api/2/issue/10001"}
+	event.Jira = &JiraInstance{Url: "http://jira.internal:8080"}
+	header, err = defaultSlackRenderer.Header(event)
+	assert.Nil(t, err)
+	assert.Equal(t, "<https://jira.example.com/jira/browse/HDDS-1|HDDS-1> *Test issue*", header)
+
+	event.Jira = &JiraInstance{BrowseUrl: "https://issues.apache.org/jira/", DisplayName: "ASF", Icon: ":feather:"}
+	header, err = defaultSlackRenderer.Header(event)
+	assert.Nil(t, err)
+	assert.Equal(t, ":feather: ASF <https://issues.apache.org/jira/browse/HDDS-1|HDDS-1> *Test issue*", header)
+}
+
+// TestConsoleChangeFormat compares the built-in change template with the output of the earlier console adapter.
+func TestConsoleChangeFormat(t *testing.T) {
+	created := time.Date(2018, 3, 1, 10, 0, 0, 0, time.UTC)
+	changes := []*ChangeItem{
+		{Field: "resolution", ToString: "Fixed", AuthorName: "Marton Elek"},
+		{Field: "status", FromString: "Open", ToString: "Patch Available", AuthorName: "Marton Elek"},
+		{Field: "assignee", FromString: "Anu Engineer", AuthorName: "Anu Engineer"},
+	}
+	for _, item := range changes {
+		item.BaseIssueInfo = BaseIssueInfo{IssueKey: "HDDS-1", IssueSummary: "Test issue", Created: created}
+		from := ""
+		if item.FromString != "" {
+			from = fmt.Sprintf("%s --> ", item.FromString)
+		}
+		expected := fmt.Sprintf("   %s -- %s: %s%s (%s)\n\n",
+			created.Format("2006-01-02 15:04"), item.Field, from, item.ToString, item.AuthorName)
+
+		text, err := defaultConsoleRenderer.Event(changeEvent(item))
+		assert.Nil(t, err)
+		assert.Equal(t, expected, text)
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	var all bool
+	var runCmd = &cobra.Command{
+		Use:   "run [pipeline...]",
+		Short: "Run the pipelines of the config file",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			config, err := LoadConfig(cmd.Flag("config").Value.String())
+			if err != nil {
+				return err
+			}
+			names := args
+			if all {
+				names = config.PipelineNames()
+			} else if len(names) == 0 {
+				return errors.New("Pipeline name is required (or --all to run all the pipelines)")
+			}
+			for _, name := range names {
+				if _, ok := config.Pipelines[name]; !ok {
+					return errors.New("Unknown pipeline: " + name)
+				}
+			}
+
+			ctx, cancel := signalContext()
+			defer cancel()
+			clients := make(map[string]*JiraClient)
+			var firstErr error
+			for _, name := range names {
+				if stopRequested(ctx) {
+					break
+				}
+				log.Print("Running pipeline " + name)
+				runner, err := NewPipelineRunner(config, name, clients)
+				if err == nil {
+					err = runner.Run(ctx)
+					runner.Close()
+				}
+				if err != nil {
+					log.Print("Pipeline " + name + " is failed: " + err.Error())
+					if firstErr == nil {
+						firstErr = err
+					}
+				}
+			}
+			return firstErr
+		},
+	}
+	runCmd.Flags().BoolVar(&all, "all", false, "Run all the pipelines of the config file")
+	rootCmd.AddCommand(runCmd)
+}
+
+// PipelineRunner runs a pipeline of the config file. The jira client and the database connections are kept open
+// between the runs.
+type PipelineRunner struct {
+	Name     string
+	Selector string
+	client   *JiraClient
+	sinks    []SinkConfig
+	dbs      map[int]*DbAdapter
+	// Results of the sinks in the last run.
+	Results []SinkResult
+}
+
+// NewPipelineRunner creates the runner of the pipeline. The runner should be closed after the use.
+func NewPipelineRunner(config *Config, name string, clients map[string]*JiraClient) (*PipelineRunner, error) {
+	client, err := config.pipelineClient(name, clients)
+	if err != nil {
+		return nil, err
+	}
+	jiraName, err := config.jiraOf(name)
+	if err != nil {
+		return nil, err
+	}
+	runner := PipelineRunner{
+		Name:     name,
+		Selector: getHash(jiraName + ":" + name),
+		client:   &client,
+		sinks:    config.Pipelines[name].Sinks,
+		dbs:      make(map[int]*DbAdapter),
+	}
+	return &runner, nil
+}
+
+// lockKey identifies the pipeline on its jira connection. Every pipeline has its own selector, so the pipelines with
+// the same query could be executed at the same time.
+func (runner *PipelineRunner) lockKey() string {
+	return runner.Selector
+}
+
+// Run retrieves the changes of the pipeline once and sends them to all the sinks (see FanOutAdapter). The result of
+// the sinks is available from Results after the run.
+func (runner *PipelineRunner) Run(ctx context.Context) error {
+	fanOut := runner.FanOut()
+	err := process(ctx, runner.client, fanOut)
+	runner.Results = fanOut.Results()
+	return err
+}
+
+// FanOut returns the adapters of all the sinks of the pipeline. The sinks which couldn't be created are reported as
+// failed sinks.
+func (runner *PipelineRunner) FanOut() *FanOutAdapter {
+	fanOut := NewFanOutAdapter()
+	for idx, sink := range runner.sinks {
+		adapter, err := runner.adapter(idx, sink)
+		if err != nil {
+			log.Print("Sink " + sink.name(idx) + " couldn't be created: " + err.Error())
+			fanOut.AddFailed(sink.name(idx), err)
+			continue
+		}
+		fanOut.Add(Sink{Name: sink.name(idx), Adapter: adapter})
+	}
+	return fanOut
+}
+
+// StorageAdapters returns the adapters of the storage (db) sinks of the pipeline. The notification sinks (console,
+// slack) are skipped.
+func (runner *PipelineRunner) StorageAdapters() ([]Adapter, error) {
+	adapters := make([]Adapter, 0, len(runner.sinks))
+	for idx, sink := range runner.sinks {
+		if sink.Type != "db" {
+			log.Print("Skipping sink " + sink.name(idx) + ", only the db sinks are supported")
+			continue
+		}
+		adapter, err := runner.adapter(idx, sink)
+		if err != nil {
+			return nil, err
+		}
+		adapters = append(adapters, adapter)
+	}
+	if len(adapters) == 0 {
+		return nil, errors.New("Pipeline " + runner.Name + " has no db sink")
+	}
+	return adapters, nil
+}
+
+func (runner *PipelineRunner) Close() {
+	for _, dbAdapter := range runner.dbs {
+		dbAdapter.Close()
+	}
+}
+
+// adapter returns the adapter of the sink, wrapped with the filter of the sink (if any).
+func (runner *PipelineRunner) adapter(idx int, sink SinkConfig) (Adapter, error) {
+	adapter, err := runner.sinkAdapter(idx, sink)
+	if err != nil || sink.Filter == nil {
+		return adapter, err
+	}
+	return &FilterAdapter{Adapter: adapter, Filter: sink.Filter}, nil
+}
+
+// sinkAdapter creates the adapter of the sink. The console and slack adapters collect the changes of one run, so they
+// are created for every run; the database connection is opened only once.
+func (runner *PipelineRunner) sinkAdapter(idx int, sink SinkConfig) (Adapter, error) {
+	switch sink.Type {
+	case "console":
+		renderer, err := sink.renderer()
+		if err != nil {
+			return nil, err
+		}
+		adapter := NewConsoleAdapter()
+		adapter.Renderer = renderer
+		adapter.Sink = sink.name(idx)
+		return adapter, nil
+	case "slack":
+		token := sink.Token
+		token.Name = "slack token"
+		if token.Env == "" {
+			token.Env = "SLACK_TOKEN"
+		}
+		resolved, err := token.Resolve()
+		if err != nil {
+			return nil, err
+		}
+		renderer, err := sink.renderer()
+		if err != nil {
+			return nil, err
+		}
+		adapter := NewSlackAdapter(sink.Channel, resolved)
+		adapter.Renderer = renderer
+		adapter.Threads = sink.Threads
+		adapter.ThreadAge = sink.ThreadAge
+		adapter.Broadcast = sink.Broadcast
+		adapter.Routes = sink.Routes
+		adapter.Sink = sink.name(idx)
+		if sink.Layout != "" {
+			adapter.Layout = sink.Layout
+		}
+		return adapter, nil
+	case "db":
+		if dbAdapter, ok := runner.dbs[idx]; ok {
+			return dbAdapter, nil
+		}
+		pgConfig := PostgresConfig{
+			Host:     sink.Host,
+			Username: sink.Username,
+			Db:       sink.Database,
+		}
+		if pgConfig.Host == "" {
+			pgConfig.Host = "localhost"
+		}
+		if pgConfig.Username == "" {
+			pgConfig.Username = "postgres"
+		}
+		if pgConfig.Db == "" {
+			pgConfig.Db = "jira"
+		}
+		password := sink.Password
+		password.Name = "postgres password"
+		if password.Env == "" {
+			password.Env = "PGPASSWORD"
+		}
+		var err error
+		pgConfig.Password, err = password.Resolve()
+		if err != nil {
+			return nil, err
+		}
+		dbAdapter, err := OpenDbAdapter(pgConfig)
+		if err != nil {
+			return nil, err
+		}
+		runner.dbs[idx] = dbAdapter
+		return dbAdapter, nil
+	default:
+		return nil, errors.New("Unknown sink type: " + sink.Type)
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule defines when a pipeline is executed by the daemon.
+type Schedule interface {
+	// Next returns the next execution time after t.
+	Next(t time.Time) time.Time
+}
+
+// ParseSchedule parses the schedule of a pipeline. Supported formats:
+//
+//  * duration (eg. 15m) or @every <duration>: fixed interval between the runs
+//  * @hourly, @daily, @weekly
+//  * standard cron expression with five fields: minute, hour, day of month, month, day of week (eg. */10 8-18 * * 1-5)
+func ParseSchedule(spec string) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	switch spec {
+	case "":
+		return nil, errors.New("Schedule is empty")
+	case "@hourly":
+		spec = "0 * * * *"
+	case "@daily":
+		spec = "0 0 * * *"
+	case "@weekly":
+		spec = "0 0 * * 0"
+	}
+	every := strings.TrimPrefix(spec, "@every ")
+	if interval, err := time.ParseDuration(strings.TrimSpace(every)); err == nil {
+		if interval < time.Second {
+			return nil, errors.New("Schedule interval is too short: " + spec)
+		}
+		return intervalSchedule{interval: interval}, nil
+	}
+	return parseCron(spec)
+}
+
+type intervalSchedule struct {
+	interval time.Duration
+}
+
+func (schedule intervalSchedule) Next(t time.Time) time.Time {
+	return t.Add(schedule.interval)
+}
+
+// cronSchedule is a parsed cron expression. Every field is a bit set of the allowed values.
+type cronSchedule struct {
+	minute     uint64
+	hour       uint64
+	dayOfMonth uint64
+	month      uint64
+	dayOfWeek  uint64
+	// true if the day of month / day of week field is restricted (not *)
+	domRestricted bool
+	dowRestricted bool
+}
+
+func parseCron(spec string) (Schedule, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, errors.New("Invalid schedule (duration or cron expression with 5 fields is expected): " + spec)
+	}
+	var schedule cronSchedule
+	var err error
+	bounds := []struct {
+		target   *uint64
+		min, max int
+	}{
+		{&schedule.minute, 0, 59},
+		{&schedule.hour, 0, 23},
+		{&schedule.dayOfMonth, 1, 31},
+		{&schedule.month, 1, 12},
+		{&schedule.dayOfWeek, 0, 7},
+	}
+	for idx, field := range fields {
+		*bounds[idx].target, err = parseCronField(field, bounds[idx].min, bounds[idx].max)
+		if err != nil {
+			return nil, errors.New("Invalid schedule " + spec + ": " + err.Error())
+		}
+	}
+	// both 0 and 7 are Sunday
+	if schedule.dayOfWeek&(1<<7) != 0 {
+		schedule.dayOfWeek |= 1
+	}
+	schedule.domRestricted = fields[2] != "*"
+	schedule.dowRestricted = fields[4] != "*"
+	return schedule, nil
+}
+
+// parseCronField parses a comma separated list of values, ranges (1-5) and steps (*/10, 0-30/5).
+func parseCronField(field string, min int, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if slash := strings.Index(part, "/"); slash >= 0 {
+			var err error
+			step, err = strconv.Atoi(part[slash+1:])
+			if err != nil || step <= 0 {
+				return 0, errors.New("invalid step: " + part)
+			}
+			part = part[:slash]
+		}
+		start, end := min, max
+		if part != "*" {
+			var err error
+			if dash := strings.Index(part, "-"); dash >= 0 {
+				start, err = strconv.Atoi(part[:dash])
+				if err == nil {
+					end, err = strconv.Atoi(part[dash+1:])
+				}
+			} else {
+				start, err = strconv.Atoi(part)
+				end = start
+				if step > 1 {
+					end = max
+				}
+			}
+			if err != nil {
+				return 0, errors.New("invalid value: " + part)
+			}
+		}
+		if start < min || end > max || start > end {
+			return 0, errors.New("value is out of range: " + part)
+		}
+		for value := start; value <= end; value += step {
+			bits |= 1 << uint(value)
+		}
+	}
+	return bits, nil
+}
+
+// Next returns the first matching minute after t (in the local time zone). Following the cron convention, if both the
+// day of month and the day of week are restricted, a day matching either of them is accepted.
+func (schedule cronSchedule) Next(t time.Time) time.Time {
+	next := t.Truncate(time.Minute).Add(time.Minute)
+	// every matching time is repeated at least once in 5 years (Feb 29)
+	limit := next.AddDate(5, 0, 0)
+	for next.Before(limit) {
+		if !schedule.matchesDay(next) {
+			next = time.Date(next.Year(), next.Month(), next.Day()+1, 0, 0, 0, 0, next.Location())
+			continue
+		}
+		if schedule.hour&(1<<uint(next.Hour())) == 0 {
+			next = time.Date(next.Year(), next.Month(), next.Day(), next.Hour()+1, 0, 0, 0, next.Location())
+			continue
+		}
+		if schedule.minute&(1<<uint(next.Minute())) == 0 {
+			next = next.Add(time.Minute)
+			continue
+		}
+		return next
+	}
+	return time.Time{}
+}
+
+func (schedule cronSchedule) matchesDay(t time.Time) bool {
+	if schedule.month&(1<<uint(t.Month())) == 0 {
+		return false
+	}
+	dom := schedule.dayOfMonth&(1<<uint(t.Day())) != 0
+	dow := schedule.dayOfWeek&(1<<uint(t.Weekday())) != 0
+	if schedule.domRestricted && schedule.dowRestricted {
+		return dom || dow
+	}
+	return dom && dow
+}
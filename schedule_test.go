@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCronSchedule(t *testing.T) {
+	schedule, err := ParseSchedule("*/15 8-18 * * 1-5")
+	assert.Nil(t, err)
+
+	// Friday evening -> Monday morning
+	friday := time.Date(2018, 3, 9, 18, 50, 0, 0, time.Local)
+	assert.Equal(t, time.Date(2018, 3, 12, 8, 0, 0, 0, time.Local), schedule.Next(friday))
+
+	monday := time.Date(2018, 3, 12, 9, 7, 30, 0, time.Local)
+	assert.Equal(t, time.Date(2018, 3, 12, 9, 15, 0, 0, time.Local), schedule.Next(monday))
+}
+
+func TestParseSchedule(t *testing.T) {
+	schedule, err := ParseSchedule("@every 10m")
+	assert.Nil(t, err)
+	now := time.Now()
+	assert.Equal(t, now.Add(10*time.Minute), schedule.Next(now))
+
+	_, err = ParseSchedule("* * *")
+	assert.NotNil(t, err)
+	_, err = ParseSchedule("60 * * * *")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/elek/jira-retriever/jiradata"
+)
+
+const defaultPageSize = 50
+
+// Number of issues re-requested from the previous page. Issues which are updated during the run are moved to the
+// end of the result set and the following issues are shifted back. The overlap makes sure that the shifted issues
+// are not skipped. It's at most the half of the page.
+const pageOverlap = 5
+
+// SearchPager walks over all the pages of a jira search using startAt/maxResults.
+type SearchPager struct {
+	client   *JiraClient
+	jql      string
+	PageSize int
+	StartAt  int
+	Total    int
+	Fetched  int
+	lastKey  string
+	seen     map[string]string
+	done     bool
+}
+
+func NewSearchPager(client *JiraClient, jql string) *SearchPager {
+	pageSize := client.PageSize
+	if pageSize <= 0 {
+		pageSize = defaultPageSize
+	}
+	return &SearchPager{
+		client:   client,
+		jql:      jql,
+		PageSize: pageSize,
+		seen:     make(map[string]string),
+	}
+}
+
+func (pager *SearchPager) HasNext() bool {
+	return !pager.done
+}
+
+// Next returns the next page of the search. Issues which are already returned by a previous page (with the same
+// updated timestamp) are removed from the page.
+func (pager *SearchPager) Next(ctx context.Context) (*jiradata.SearchResults, error) {
+	parameters := url.Values{
+		"jql":        []string{pager.jql},
+		"expand":     []string{"changelog,comments"},
+		"fields":     []string{"*all"},
+		"startAt":    []string{strconv.Itoa(pager.StartAt)},
+		"maxResults": []string{strconv.Itoa(pager.PageSize)},
+	}
+	jsonContent, err := pager.client.queryWithParameters(ctx, "/search", parameters)
+	if err != nil {
+		return nil, err
+	}
+
+	var searchResults jiradata.SearchResults
+	err = decode(jsonContent, &searchResults, "Search result")
+	if err != nil {
+		return nil, err
+	}
+	if len(searchResults.ErrorMessages) > 0 {
+		return nil, &JiraError{What: "Search", Messages: searchResults.ErrorMessages}
+	}
+
+	page := searchResults.Issues
+	returned := len(page)
+	pager.Total = searchResults.Total
+	// the last issue of the previous page is requested again only if the pages are overlapped
+	if pager.lastKey != "" && !containsKey(page, pager.lastKey) {
+		log.Print(fmt.Sprintf("The result set is shifted more than %d issues during the paging, some issues may be "+
+			"skipped", pageOverlap))
+	}
+
+	issues := make(jiradata.Issues, 0, returned)
+	for _, issue := range page {
+		updated, _ := issue.Fields["updated"].(string)
+		if previous, found := pager.seen[issue.Key]; found && previous == updated {
+			continue
+		}
+		pager.seen[issue.Key] = updated
+		issues = append(issues, issue)
+	}
+	searchResults.Issues = issues
+	pager.Fetched += len(issues)
+
+	overlap := pageOverlap
+	if overlap > returned/2 {
+		overlap = returned / 2
+	}
+	nextStartAt := searchResults.StartAt + returned - overlap
+	pager.lastKey = ""
+	if overlap > 0 {
+		pager.lastKey = page[returned-1].Key
+	}
+	// a page could contain only already returned issues (if the result set is shifted), it's not the end of the search
+	if returned == 0 || searchResults.StartAt+returned >= searchResults.Total || nextStartAt <= pager.StartAt {
+		pager.done = true
+	}
+	pager.StartAt = nextStartAt
+	return &searchResults, nil
+}
+
+// Progress returns a human readable summary of the paging.
+func (pager *SearchPager) Progress() string {
+	return fmt.Sprintf("%d/%d issues are retrieved", pager.Fetched, pager.Total)
+}
+
+// splitLastGroup splits the issues (ordered by the updated time) before the issues with the same updated time as the
+// last one. The rest of the last group could be on the next page.
+func splitLastGroup(issues jiradata.Issues) (jiradata.Issues, jiradata.Issues) {
+	if len(issues) == 0 {
+		return issues, nil
+	}
+	last, _ := issues[len(issues)-1].Fields["updated"].(string)
+	split := len(issues)
+	for split > 0 {
+		updated, _ := issues[split-1].Fields["updated"].(string)
+		if updated != last {
+			break
+		}
+		split--
+	}
+	return issues[:split], issues[split:]
+}
+
+func containsKey(issues jiradata.Issues, key string) bool {
+	for _, issue := range issues {
+		if issue.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// searchQuery returns the JQL to retrieve all the issues updated after lastUpdated in a stable order.
+func searchQuery(lastUpdated time.Time, queryFragment string) string {
+	sinceMs := lastUpdated.UnixNano() / 1000000
+	if sinceMs < 0 {
+		sinceMs = 0
+	}
+	query := "updated > " + strconv.FormatInt(sinceMs, 10) + " ORDER BY updated ASC, key ASC"
+	if len(queryFragment) > 0 {
+		query = "(" + queryFragment + ") AND " + query
+	}
+	return query
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/elek/jira-retriever/jiradata"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeTransport responds to the jira calls without network.
+type fakeTransport func(req *http.Request) (int, string)
+
+func (transport fakeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	status, body := transport(req)
+	return &http.Response{
+		StatusCode: status,
+		Body:       ioutil.NopCloser(strings.NewReader(body)),
+		Header:     make(http.Header),
+		Request:    req,
+	}, nil
+}
+
+func fakeClient(transport fakeTransport) *JiraClient {
+	return &JiraClient{Url: "https://jira.example.com", httpClient: &http.Client{Transport: transport}}
+}
+
+// testIssues creates issues from KEY@updated (seconds of 2018-03-01 10:00) pairs.
+func testIssues(keys ...string) jiradata.Issues {
+	var issues jiradata.Issues
+	for _, key := range keys {
+		updated := "00"
+		if parts := strings.Split(key, "@"); len(parts) == 2 {
+			key, updated = parts[0], parts[1]
+		}
+		issues = append(issues, &jiradata.Issue{Key: key, Fields: map[string]interface{}{
+			"updated": "2018-03-01T10:00:" + updated + ".000+0000",
+		}})
+	}
+	return issues
+}
+
+func issueKeys(issues jiradata.Issues) []string {
+	keys := []string{}
+	for _, issue := range issues {
+		keys = append(keys, issue.Key)
+	}
+	return keys
+}
+
+func TestSearchPager(t *testing.T) {
+	cases := []struct {
+		name     string
+		pageSize int
+		total    int
+		// the pages returned by jira one after the other
+		pages   [][]string
+		starts  []int
+		keys    []string
+		shifted bool
+	}{
+		{
+			name:     "overlapping pages",
+			pageSize: 10,
+			total:    12,
+			pages: [][]string{
+				{"A-1", "A-2", "A-3", "A-4", "A-5", "A-6", "A-7", "A-8", "A-9", "A-10"},
+				{"A-6", "A-7", "A-8", "A-9", "A-10", "A-11", "A-12"},
+			},
+			starts: []int{0, 5},
+			keys:   []string{"A-1", "A-2", "A-3", "A-4", "A-5", "A-6", "A-7", "A-8", "A-9", "A-10", "A-11", "A-12"},
+		},
+		{
+			name:     "page size below the overlap",
+			pageSize: 2,
+			total:    5,
+			pages:    [][]string{{"A-1", "A-2"}, {"A-2", "A-3"}, {"A-3", "A-4"}, {"A-4", "A-5"}},
+			starts:   []int{0, 1, 2, 3},
+			keys:     []string{"A-1", "A-2", "A-3", "A-4", "A-5"},
+		},
+		{
+			name:     "page with returned issues only",
+			pageSize: 4,
+			total:    7,
+			pages:    [][]string{{"A-1", "A-2", "A-3", "A-4"}, {"A-3", "A-4"}, {"A-4", "A-5", "A-6", "A-7"}},
+			starts:   []int{0, 2, 3},
+			keys:     []string{"A-1", "A-2", "A-3", "A-4", "A-5", "A-6", "A-7"},
+		},
+		{
+			name:     "shifted result set",
+			pageSize: 4,
+			total:    6,
+			pages:    [][]string{{"A-1", "A-2", "A-3", "A-4"}, {"A-6", "A-7", "A-1@30", "A-2@30"}},
+			starts:   []int{0, 2},
+			keys:     []string{"A-1", "A-2", "A-3", "A-4", "A-6", "A-7", "A-1", "A-2"},
+			shifted:  true,
+		},
+	}
+	for _, c := range cases {
+		var starts []int
+		transport := fakeTransport(func(req *http.Request) (int, string) {
+			startAt, _ := strconv.Atoi(req.URL.Query().Get("startAt"))
+			content, _ := json.Marshal(jiradata.SearchResults{
+				StartAt: startAt,
+				Total:   c.total,
+				Issues:  testIssues(c.pages[len(starts)]...),
+			})
+			starts = append(starts, startAt)
+			return http.StatusOK, string(content)
+		})
+		var output bytes.Buffer
+		log.SetOutput(&output)
+
+		client := fakeClient(transport)
+		client.PageSize = c.pageSize
+		pager := NewSearchPager(client, "project = HDDS")
+		var issues jiradata.Issues
+		for pager.HasNext() {
+			results, err := pager.Next(context.Background())
+			assert.Nil(t, err, c.name)
+			issues = append(issues, results.Issues...)
+		}
+		log.SetOutput(os.Stderr)
+
+		assert.Equal(t, c.starts, starts, c.name)
+		assert.Equal(t, c.keys, issueKeys(issues), c.name)
+		assert.Equal(t, c.shifted, strings.Contains(output.String(), "shifted"), c.name)
+	}
+}
+
+func TestSplitLastGroup(t *testing.T) {
+	complete, pending := splitLastGroup(testIssues("A-1@01", "A-2@02", "A-3@03", "A-4@03"))
+	assert.Equal(t, []string{"A-1", "A-2"}, issueKeys(complete))
+	assert.Equal(t, []string{"A-3", "A-4"}, issueKeys(pending))
+
+	complete, pending = splitLastGroup(testIssues("A-1@03", "A-2@03"))
+	assert.Equal(t, []string{}, issueKeys(complete))
+	assert.Equal(t, []string{"A-1", "A-2"}, issueKeys(pending))
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+type stopKey struct{}
+
+// signalContext returns the context of a command. The first SIGINT/SIGTERM requests a graceful stop (see
+// stopRequested): the current page is finished and committed and the last updated time is saved. The second signal
+// cancels the context and aborts the in-flight calls.
+func signalContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	stop := make(chan struct{})
+	ctx = context.WithValue(ctx, stopKey{}, stop)
+
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-signals:
+			log.Print("Stopping after the current page, send the signal again to abort immediately")
+			close(stop)
+		case <-ctx.Done():
+			return
+		}
+		select {
+		case <-signals:
+			log.Print("Aborting")
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, func() {
+		signal.Stop(signals)
+		cancel()
+	}
+}
+
+// stopRequested returns true if a graceful stop is requested for the context.
+func stopRequested(ctx context.Context) bool {
+	select {
+	case <-stopChannel(ctx):
+		return true
+	default:
+		return false
+	}
+}
+
+// stopChannel returns a channel which is closed when a graceful stop is requested. The channel is nil (never closed) if
+// the context is not created by signalContext.
+func stopChannel(ctx context.Context) <-chan struct{} {
+	stop, _ := ctx.Value(stopKey{}).(chan struct{})
+	return stop
+}
@@ -1,165 +1,330 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"time"
 	"github.com/spf13/cobra"
-	"fmt"
-	"strings"
 	"sort"
-	"bytes"
 	"github.com/nlopes/slack"
 	"strconv"
 	"encoding/json"
+	"log"
+	"strings"
 )
 
 type SlackAdapter struct {
-	Changes   []WithBaseIssueInformation
+	Events    []*Event
+	Renderer  *Renderer
 	selector  string
 	fileState FileState
 	Channel   string
 	Token     string
+	// Threads enables one thread per issue, the threads are persisted per channel (see ThreadStore).
+	Threads bool
+	// ThreadAge is the age of the threads after a new thread is started for the issue (0: no limit).
+	ThreadAge time.Duration
+	// Broadcast is the list of the event types which are also sent to the channel from the threads.
+	Broadcast StringList
+	// Layout is the format of the messages: attachments (legacy) or blocks (Block Kit).
+	Layout string
+	// Routes send the events to other channels than the default Channel.
+	Routes []SlackRoute
+	// Sink is the name of the sink in a pipeline, every sink has its own state file.
+	Sink string
 }
 
 func init() {
-	var token, channel string
+	var channel string
+	var threads bool
+	var threadAge time.Duration
+	var broadcast []string
+	var layout string
 	var consoleCmd = &cobra.Command{
 		Use:   "slack",
 		Short: "Send the latest changes to slack",
-		Run: func(cmd *cobra.Command, args []string) {
-
-			adapter := SlackAdapter{
-				Channel: channel,
-				Token:   token,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if layout != layoutAttachments && layout != layoutBlocks {
+				return errors.New("Unknown slack layout: " + layout)
+			}
+			token, err := resolveSecretFlag(cmd, "token", "SLACK_TOKEN")
+			if err != nil {
+				return err
 			}
-			adapter.Changes = make([]WithBaseIssueInformation, 0)
 
-			config := FromFlags(cmd)
-			process(&config, &adapter)
+			adapter := NewSlackAdapter(channel, token)
+			adapter.Threads = threads
+			adapter.ThreadAge = threadAge
+			adapter.Broadcast = broadcast
+			adapter.Layout = layout
+
+			config, err := FromFlags(cmd)
+			if err != nil {
+				return err
+			}
+			ctx, cancel := signalContext()
+			defer cancel()
+			return process(ctx, &config, adapter)
 
 		},
 	}
-	consoleCmd.Flags().StringVar(&token, "token", "", "Slack authorization token")
+	consoleCmd.Flags().String("token", "", "Slack authorization token")
+	addSecretFlags(consoleCmd.Flags(), "token", "slack token")
 	consoleCmd.Flags().StringVar(&channel, "channel", "sandbox", "Channel to send to message to")
+	consoleCmd.Flags().BoolVar(&threads, "threads", false, "Send the changes of an issue to one thread")
+	consoleCmd.Flags().DurationVar(&threadAge, "thread-age", 0, "Age of the threads after a new thread is "+
+		"started for the issue (0: no limit)")
+	consoleCmd.Flags().StringSliceVar(&broadcast, "broadcast", nil, "Event types which are also sent to the "+
+		"channel from the threads (eg. issue.status_changed)")
+	consoleCmd.Flags().StringVar(&layout, "layout", layoutAttachments, "Layout of the messages: attachments "+
+		"(legacy message attachments) or blocks (Block Kit)")
 	rootCmd.AddCommand(consoleCmd)
 }
 
-func (slackAdapter *SlackAdapter) saveIssue(issue JiraItem, selector string) error {
-	if issue.Issue.Fields["created"] == issue.Issue.Fields["updated"] {
-		slackAdapter.Changes = append(slackAdapter.Changes, &issue)
+func NewSlackAdapter(channel string, token string) *SlackAdapter {
+	return &SlackAdapter{
+		Channel:  channel,
+		Token:    token,
+		Events:   make([]*Event, 0),
+		Renderer: defaultSlackRenderer,
+		Layout:   layoutAttachments,
 	}
-	return nil
-}
-
-func (slackAdapter *SlackAdapter) saveChange(item ChangeItem, selector string) error {
-	slackAdapter.Changes = append(slackAdapter.Changes, &item)
-	return nil
 }
 
-func (slackAdapter *SlackAdapter) saveComment(comment CommentItem, selector string) error {
-	slackAdapter.Changes = append(slackAdapter.Changes, &comment)
+// saveEvent collects the events to print them at the end. The snapshots of the updated issues are not shown.
+func (slackAdapter *SlackAdapter) saveEvent(ctx context.Context, event *Event, selector string) error {
+	if event.Type != EventIssueUpdated {
+		slackAdapter.Events = append(slackAdapter.Events, event)
+	}
 	return nil
 }
 
-func (slackAdapter *SlackAdapter) getLastUpdated(selector string) (time.Time, error) {
-	state := CreateFileState(selector)
+func (slackAdapter *SlackAdapter) getLastUpdated(ctx context.Context, selector string) (time.Time, error) {
+	state := CreateSinkFileState(selector, slackAdapter.Sink)
 	return state.read()
 }
-func (slackAdapter *SlackAdapter) saveLastUpdated(lastUpdated time.Time, selector string) error {
-	state := CreateFileState(selector)
+func (slackAdapter *SlackAdapter) saveLastUpdated(ctx context.Context, lastUpdated time.Time, selector string) error {
+	state := CreateSinkFileState(selector, slackAdapter.Sink)
 	return state.write(lastUpdated)
 }
 
-func (slackAdapter *SlackAdapter) Commit() error {
+func (slackAdapter *SlackAdapter) Commit(ctx context.Context) error {
+	return nil
+}
+func (slackAdapter *SlackAdapter) Begin(ctx context.Context) error {
 	return nil
 }
-func (slackAdapter *SlackAdapter) Begin() error {
+func (slackAdapter *SlackAdapter) Rollback(ctx context.Context) error {
 	return nil
 }
-func (slackAdapter *SlackAdapter) Finish() error {
-	sort.Slice(slackAdapter.Changes, func(a int, b int) bool {
-		return slackAdapter.Changes[a].GetCreated().Before(slackAdapter.Changes[b].GetCreated())
+// Finish sends the collected events. Every channel gets its own messages of the events routed to it (see SlackRoute).
+func (slackAdapter *SlackAdapter) Finish(ctx context.Context) error {
+	sort.Slice(slackAdapter.Events, func(a int, b int) bool {
+		return slackAdapter.Events[a].Timestamp.Before(slackAdapter.Events[b].Timestamp)
 	})
 
-	var buffer bytes.Buffer
-	attachments := make([]slack.Attachment, 0)
-	prevKey := ""
-	for _, genericItem := range slackAdapter.Changes {
-		if prevKey != genericItem.GetIssueKey() {
-			if buffer.Len() > 0 {
-				slackAdapter.PostMessage(buffer.String(), attachments)
-				buffer.Reset()
-				attachments = make([]slack.Attachment, 0)
-
+	var channels []string
+	routed := make(map[string][]*Event)
+	var dropped []string
+	for _, event := range slackAdapter.Events {
+		eventChannels := slackAdapter.channelsOf(event)
+		if len(eventChannels) == 0 {
+			dropped = append(dropped, event.ID+" ("+event.IssueKey+")")
+		}
+		for _, channel := range eventChannels {
+			if _, ok := routed[channel]; !ok {
+				channels = append(channels, channel)
 			}
-			buffer.WriteString(fmt.Sprintf("<https://issues.apache.org/jira/browse/"+
-				"%s|%s> *%s*",
-				genericItem.GetIssueKey(),
-				genericItem.GetIssueKey(),
-				genericItem.GetIssueSummary()))
+			routed[channel] = append(routed[channel], event)
 		}
-		switch item := genericItem.(type) {
-		case *ChangeItem:
-			from := ""
+	}
+	if len(dropped) > 0 {
+		log.Printf("%d events are not matched by any slack route and there is no default channel, they are not sent: %s",
+			len(dropped), strings.Join(dropped, ", "))
+	}
+	for _, channel := range channels {
+		err := slackAdapter.sendChannel(ctx, channel, routed[channel])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SlackRoute sends the events matched by the rule to the channels. A route without rule matches all the events.
+type SlackRoute struct {
+	Match    *FilterRule `yaml:"match"`
+	Channels StringList  `yaml:"channels"`
+}
 
-			if item.FromString != "" {
-				from = fmt.Sprintf("%s --> ", item.FromString)
+// channelsOf returns the channels of all the routes matching the event, or the default channel if none of the routes
+// is matched. It's empty if there is no default channel.
+func (slackAdapter *SlackAdapter) channelsOf(event *Event) []string {
+	var channels []string
+	seen := make(map[string]bool)
+	for _, route := range slackAdapter.Routes {
+		if route.Match != nil && !route.Match.Match(event) {
+			continue
+		}
+		for _, channel := range route.Channels {
+			if !seen[channel] {
+				seen[channel] = true
+				channels = append(channels, channel)
 			}
+		}
+	}
+	if len(channels) == 0 && slackAdapter.Channel != "" {
+		channels = append(channels, slackAdapter.Channel)
+	}
+	return channels
+}
 
-			if item.Field != "Comment" {
-				attachment := slack.Attachment{
-					AuthorName: item.AuthorName,
-					Title:      item.Field + " field is changed",
-					Text:       fmt.Sprintf("%s %s", from, item.ToString),
-					MarkdownIn: []string{"text", "footer", "title"},
-					Ts:         json.Number(strconv.Itoa(int(genericItem.GetCreated().Unix()))),
-				}
-				attachments = append(attachments, attachment)
+// slackChannel is a channel of one Finish with its threads.
+type slackChannel struct {
+	Name  string
+	store *ThreadStore
+}
+
+// sendChannel sends the events of a channel. The consecutive events of the same issue are sent together.
+func (slackAdapter *SlackAdapter) sendChannel(ctx context.Context, name string, events []*Event) error {
+	channel := slackChannel{Name: name}
+	if slackAdapter.Threads {
+		threadStoreMutex.Lock()
+		defer threadStoreMutex.Unlock()
+		var err error
+		channel.store, err = OpenThreadStore(threadStoreFile(name))
+		if err != nil {
+			return err
+		}
+	}
+
+	var issueEvents []*Event
+	for idx, event := range events {
+		if change, ok := event.Payload.(*ChangeItem); !ok || change.Field != "Comment" {
+			issueEvents = append(issueEvents, event)
+		}
+		last := idx == len(events)-1
+		if last || events[idx+1].IssueKey != event.IssueKey {
+			err := slackAdapter.sendIssue(ctx, &channel, event, issueEvents)
+			if err != nil {
+				return err
 			}
-		case *JiraItem:
-			creator := item.Issue.Fields["creator"].(map[string]interface{})
-
-			attachment := slack.Attachment{
-				AuthorName: creator["displayName"].(string),
-				Title:      "Issue is created",
-				Text:       item.Issue.Fields["description"].(string),
-				Ts:         json.Number(strconv.Itoa(int(genericItem.GetCreated().Unix()))),
+			issueEvents = nil
+		}
+	}
+	return nil
+}
+
+// slackMessage is one message of the changes of an issue.
+type slackMessage struct {
+	Text        string
+	Attachments []slack.Attachment
+	Blocks      []Block
+	// Events is the number of the events in the message.
+	Events    int
+	Broadcast bool
+}
+
+// sendIssue sends the events of an issue with the layout of the adapter. The issue is the last event of the issue, it's
+// used even if all the events of the issue are skipped.
+func (slackAdapter *SlackAdapter) sendIssue(ctx context.Context, channel *slackChannel, issue *Event,
+	events []*Event) error {
+	header, err := slackAdapter.Renderer.Header(issue)
+	if err != nil {
+		return err
+	}
+	broadcast := false
+	for _, event := range events {
+		broadcast = broadcast || slackAdapter.Broadcast.matchType(event.Type)
+	}
+	var messages []slackMessage
+	if slackAdapter.Layout == layoutBlocks {
+		blocks, rendered, err := slackAdapter.issueBlocks(header, events)
+		if err != nil {
+			return err
+		}
+		for _, message := range blocks {
+			messages = append(messages, slackMessage{Text: header, Blocks: message, Events: rendered,
+				Broadcast: broadcast})
+		}
+	} else {
+		attachments := make([]slack.Attachment, 0)
+		for _, event := range events {
+			title, err := slackAdapter.Renderer.Title(event)
+			if err != nil {
+				return err
 			}
-			attachments = append(attachments, attachment)
-		case *CommentItem:
-			comment := ""
-			if item.Comment.Author.DisplayName != "genericqa" && item.Comment.Author.DisplayName != "Hadoop QA" {
-				comment = item.Comment.Body
-				comment = strings.Replace(comment, "\n", "\n\n", -1)
+			text, err := slackAdapter.Renderer.Event(event)
+			if err != nil {
+				return err
 			}
-
-			attachment := slack.Attachment{
-				AuthorName: item.Comment.Author.DisplayName,
-				Title:      "Comment",
-				Text:       comment,
-				MarkdownIn: []string{"text", "footer", "title"},
-				Ts:         json.Number(strconv.Itoa(int(genericItem.GetCreated().Unix()))),
+			if title != "" || text != "" {
+				attachments = append(attachments, slack.Attachment{
+					AuthorName: event.Actor.DisplayName,
+					Title:      title,
+					Text:       text,
+					MarkdownIn: []string{"text", "footer", "title"},
+					Ts:         json.Number(strconv.Itoa(int(event.Timestamp.Unix()))),
+				})
 			}
-			attachments = append(attachments, attachment)
-
 		}
-		prevKey = genericItem.GetIssueKey()
+		messages = append(messages, slackMessage{Text: header, Attachments: attachments, Events: len(attachments),
+			Broadcast: broadcast})
+	}
+	return slackAdapter.post(ctx, channel, issue.IssueKey, messages)
+}
 
+// post sends the messages of an issue. Without thread store every message is a new message of the channel. With thread
+// store the first message of the issue starts a thread and the next messages are sent as replies of the thread (and
+// also to the channel if broadcast is requested).
+func (slackAdapter *SlackAdapter) post(ctx context.Context, channel *slackChannel, issueKey string,
+	messages []slackMessage) error {
+	store := channel.store
+	thread := ""
+	now := time.Now()
+	if store != nil {
+		thread, _ = store.Thread(issueKey, slackAdapter.ThreadAge, now)
 	}
-	if buffer.Len() > 0 {
-		slackAdapter.PostMessage(buffer.String(), attachments)
+	for _, message := range messages {
+		if thread != "" && message.Events == 0 {
+			continue
+		}
+		ts, err := slackAdapter.send(ctx, channel.Name, message, thread)
+		if err != nil {
+			return err
+		}
+		if store != nil && thread == "" {
+			thread = ts
+			store.Start(issueKey, ts, now)
+			err = store.Save(slackAdapter.ThreadAge, now)
+			if err != nil {
+				return err
+			}
+		}
 	}
-
 	return nil
 }
 
-func (slackAdapter *SlackAdapter) PostMessage(message string, attachments []slack.Attachment) {
+func (slackAdapter *SlackAdapter) send(ctx context.Context, channel string, message slackMessage,
+	thread string) (string, error) {
+	if message.Blocks != nil {
+		return slackAdapter.PostBlocks(ctx, channel, message.Text, message.Blocks, thread, message.Broadcast)
+	}
+	return slackAdapter.PostMessage(ctx, channel, message.Text, message.Attachments, thread, message.Broadcast)
+}
+
+// PostMessage sends the message to the channel (or to the thread if it's not empty) and returns its timestamp.
+func (slackAdapter *SlackAdapter) PostMessage(ctx context.Context, channel string, message string,
+	attachments []slack.Attachment, thread string, broadcast bool) (string, error) {
 	api := slack.New(slackAdapter.Token)
 	parameters := slack.NewPostMessageParameters()
 	parameters.Attachments = attachments
 	parameters.Username = "Jira changes bot"
 	parameters.EscapeText = false
-	_, response, err := api.PostMessage(slackAdapter.Channel, message, parameters)
-	if err != nil {
-		panic(response)
+	if thread != "" {
+		parameters.ThreadTimestamp = thread
+		parameters.ReplyBroadcast = broadcast
 	}
+	_, ts, err := api.PostMessageContext(ctx, channel, message, parameters)
+	return ts, err
 }
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/elek/jira-retriever/jiradata"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+func TestSlackRoutes(t *testing.T) {
+	channels := make(map[string]int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		var message map[string]interface{}
+		assert.Nil(t, json.Unmarshal(body, &message))
+		channels[message["channel"].(string)]++
+		w.Write([]byte(`{"ok": true, "ts": "1519898400.000100"}`))
+	}))
+	defer server.Close()
+	defer func(url string) { slackApiUrl = url }(slackApiUrl)
+	slackApiUrl = server.URL + "/"
+
+	var routes []SlackRoute
+	err := yaml.UnmarshalStrict([]byte(`
+- match:
+    component: SCM
+  channels: ozone-scm
+- match:
+    label: security
+  channels: [security, ozone-scm]
+`), &routes)
+	assert.Nil(t, err)
+	adapter := NewSlackAdapter("ozone", "token")
+	adapter.Layout = layoutBlocks
+	adapter.Routes = routes
+
+	event := func(key string, fields map[string]interface{}) *Event {
+		event := changeEvent(&ChangeItem{BaseIssueInfo: BaseIssueInfo{IssueKey: key}, Field: "status"})
+		event.Issue = &jiradata.Issue{Key: key, Fields: fields}
+		return event
+	}
+	scm := event("HDDS-1", map[string]interface{}{
+		"components": []interface{}{map[string]interface{}{"name": "SCM"}},
+		"labels":     []interface{}{"security"},
+	})
+	assert.Equal(t, []string{"ozone-scm", "security"}, adapter.channelsOf(scm))
+	other := event("HDDS-2", map[string]interface{}{})
+	assert.Equal(t, []string{"ozone"}, adapter.channelsOf(other))
+
+	assert.Nil(t, adapter.saveEvent(context.Background(), scm, "selector"))
+	assert.Nil(t, adapter.saveEvent(context.Background(), other, "selector"))
+	assert.Nil(t, adapter.Finish(context.Background()))
+	assert.Equal(t, map[string]int{"ozone-scm": 1, "security": 1, "ozone": 1}, channels)
+}
+
+func TestSlackRoutesWithoutDefaultChannel(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"ok": true, "ts": "1519898400.000100"}`))
+	}))
+	defer server.Close()
+	defer func(url string) { slackApiUrl = url }(slackApiUrl)
+	slackApiUrl = server.URL + "/"
+
+	adapter := NewSlackAdapter("", "token")
+	adapter.Layout = layoutBlocks
+	adapter.Routes = []SlackRoute{{Match: &FilterRule{Label: StringList{"security"}}, Channels: StringList{"security"}}}
+	event := changeEvent(&ChangeItem{BaseIssueInfo: BaseIssueInfo{IssueKey: "HDDS-2"}, HistoryId: 1, Field: "status"})
+	event.Issue = &jiradata.Issue{Key: "HDDS-2", Fields: map[string]interface{}{}}
+	assert.Equal(t, 0, len(adapter.channelsOf(event)))
+
+	var output bytes.Buffer
+	log.SetOutput(&output)
+	defer log.SetOutput(os.Stderr)
+	assert.Nil(t, adapter.saveEvent(context.Background(), event, "selector"))
+	assert.Nil(t, adapter.Finish(context.Background()))
+	assert.Contains(t, output.String(), "1 events are not matched by any slack route")
+	assert.Contains(t, output.String(), "change:1:0 (HDDS-2)")
+}
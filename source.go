@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/elek/jira-retriever/jiradata"
+)
+
+// Batch is a group of events produced by a source. The events of a batch are saved by the adapters in one transaction.
+type Batch struct {
+	Events []*Event
+	// Cursor is the position of the source after the batch. The next run resumes from the cursor of the last
+	// committed batch.
+	Cursor time.Time
+}
+
+// Source produces the changes of the issues for the adapters. The changes are independent from the way how they are
+// retrieved (jira search, webhook, file replay or test fixtures).
+type Source interface {
+	// Next returns the next batch of events or nil if there are no more events.
+	Next(ctx context.Context) (*Batch, error)
+	// Progress returns a human readable description of the position of the source.
+	Progress() string
+}
+
+// SearchSource produces the changes from the results of a jira search. One page of the search is one batch, except the
+// issues with the same updated time as the last issue of the page: they are moved to the next batch. The cursor of a
+// batch is never in the middle of the issues updated at the same time, so the next run (updated > cursor) doesn't skip
+// the rest of them.
+type SearchSource struct {
+	since    time.Time
+	pager    *SearchPager
+	enricher *Enricher
+	pending  jiradata.Issues
+}
+
+// NewSearchSource creates a source of the changes since the given time.
+func NewSearchSource(client *JiraClient, since time.Time) (*SearchSource, error) {
+	var attachmentStore *AttachmentStore
+	if client.AttachmentDir != "" {
+		var err error
+		attachmentStore, err = CreateAttachmentStore(client.AttachmentDir)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &SearchSource{
+		since:    since,
+		pager:    NewSearchPager(client, searchQuery(since, client.JQL)),
+		enricher: NewEnricher(client, since, attachmentStore),
+	}, nil
+}
+
+func (source *SearchSource) Next(ctx context.Context) (*Batch, error) {
+	var issues jiradata.Issues
+	for len(issues) == 0 {
+		if !source.pager.HasNext() {
+			issues, source.pending = source.pending, nil
+			break
+		}
+		searchResults, err := source.pager.Next(ctx)
+		if err != nil {
+			return nil, err
+		}
+		issues = append(source.pending, searchResults.Issues...)
+		source.pending = nil
+		if source.pager.HasNext() {
+			issues, source.pending = splitLastGroup(issues)
+		}
+	}
+	if len(issues) == 0 {
+		log.Print("No more results")
+		return nil, nil
+	}
+	enrichedIssues, err := source.enricher.Enrich(ctx, issues)
+	if err != nil {
+		return nil, err
+	}
+	batch := Batch{}
+	for _, enriched := range enrichedIssues {
+		events, updated, err := issueEvents(source.since, enriched)
+		if err != nil {
+			return nil, err
+		}
+		batch.Events = append(batch.Events, events...)
+		if updated.After(batch.Cursor) {
+			batch.Cursor = updated
+		}
+	}
+	return &batch, nil
+}
+
+func (source *SearchSource) Progress() string {
+	return source.pager.Progress()
+}
+
+// BatchSource returns predefined batches, eg. test fixtures.
+type BatchSource struct {
+	Batches []*Batch
+	next    int
+}
+
+func (source *BatchSource) Next(ctx context.Context) (*Batch, error) {
+	if source.next >= len(source.Batches) {
+		return nil, nil
+	}
+	source.next++
+	return source.Batches[source.next-1], nil
+}
+
+func (source *BatchSource) Progress() string {
+	return fmt.Sprintf("%d/%d batches are processed", source.next, len(source.Batches))
+}
+
+// consume sends all the events of the source to the adapter. Every batch is committed separately and the cursor of the
+// last batch is saved as the last updated time after the adapter is finished. On graceful stop (see signalContext) the current batch is finished
+// and committed, so the next run continues from the same point.
+func consume(ctx context.Context, source Source, adapter Adapter, selector string, cursor time.Time) error {
+	cursor, err := send(ctx, source, adapter, selector, cursor)
+	if err != nil {
+		return err
+	}
+	return adapterError("saveLastUpdated", adapter.saveLastUpdated(ctx, cursor, selector))
+}
+
+// send sends all the events of the source to the adapter and finishes the adapter, without saving the last updated
+// time. It returns the cursor of the last committed batch.
+func send(ctx context.Context, source Source, adapter Adapter, selector string, cursor time.Time) (time.Time, error) {
+	for {
+		if stopRequested(ctx) {
+			log.Print("Stopped, " + source.Progress())
+			break
+		}
+		batch, err := source.Next(ctx)
+		if err != nil {
+			return cursor, err
+		}
+		if batch == nil {
+			break
+		}
+		err = adapter.Begin(ctx)
+		if err != nil {
+			return cursor, adapterError("Begin", err)
+		}
+		for _, event := range batch.Events {
+			err = adapterError("saveEvent", adapter.saveEvent(ctx, event, selector))
+			if err != nil {
+				rollback(ctx, adapter)
+				return cursor, err
+			}
+		}
+		err = adapter.Commit(ctx)
+		if err != nil {
+			rollback(ctx, adapter)
+			return cursor, adapterError("Commit", err)
+		}
+		if batch.Cursor.After(cursor) {
+			cursor = batch.Cursor
+		}
+		log.Print(source.Progress())
+	}
+	// the adapters could send the changes only at the end (eg. slack), the cursor is saved only if it's succeeded
+	return cursor, adapterError("Finish", adapter.Finish(ctx))
+}
+
+// rollback rolls back the transaction of the adapter after a failure. The error of the rollback is only logged, the
+// original failure is returned by the caller.
+func rollback(ctx context.Context, adapter Adapter) {
+	err := adapter.Rollback(ctx)
+	if err != nil {
+		log.Print("Rollback is failed: " + err.Error())
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// memoryAdapter collects the events and keeps the last updated time in memory.
+type memoryAdapter struct {
+	events      []*Event
+	commits     int
+	rollbacks   int
+	lastUpdated time.Time
+	commentErr  error
+	finishErr   error
+}
+
+func (adapter *memoryAdapter) saveEvent(ctx context.Context, event *Event, selector string) error {
+	if _, ok := event.Payload.(*CommentItem); ok && adapter.commentErr != nil {
+		return adapter.commentErr
+	}
+	adapter.events = append(adapter.events, event)
+	return nil
+}
+
+func (adapter *memoryAdapter) getLastUpdated(ctx context.Context, selector string) (time.Time, error) {
+	return adapter.lastUpdated, nil
+}
+
+func (adapter *memoryAdapter) saveLastUpdated(ctx context.Context, lastUpdated time.Time, selector string) error {
+	adapter.lastUpdated = lastUpdated
+	return nil
+}
+
+func (adapter *memoryAdapter) Commit(ctx context.Context) error {
+	adapter.commits++
+	return nil
+}
+
+func (adapter *memoryAdapter) Begin(ctx context.Context) error {
+	return nil
+}
+
+func (adapter *memoryAdapter) Rollback(ctx context.Context) error {
+	adapter.rollbacks++
+	return nil
+}
+
+func (adapter *memoryAdapter) Finish(ctx context.Context) error {
+	return adapter.finishErr
+}
+
+func TestConsumeBatchSource(t *testing.T) {
+	first := time.Date(2018, 3, 1, 10, 0, 0, 0, time.UTC)
+	second := first.Add(time.Hour)
+	source := &BatchSource{Batches: []*Batch{
+		{
+			Events: []*Event{
+				changeEvent(&ChangeItem{BaseIssueInfo: BaseIssueInfo{IssueKey: "HDDS-1", Created: first}, Field: "status"}),
+				commentEvent(&CommentItem{BaseIssueInfo: BaseIssueInfo{IssueKey: "HDDS-1", Created: first}}, EventCommentAdded),
+			},
+			Cursor: first,
+		},
+		{
+			Events: []*Event{worklogEvent(&WorklogItem{BaseIssueInfo: BaseIssueInfo{IssueKey: "HDDS-2", Created: second}})},
+			Cursor: second,
+		},
+	}}
+	adapter := &memoryAdapter{}
+
+	err := consume(context.Background(), source, adapter, "selector", time.Time{})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(adapter.events))
+	assert.Equal(t, EventStatusChanged, adapter.events[0].Type)
+	assert.Equal(t, "status", adapter.events[0].Payload.(*ChangeItem).Field)
+	assert.Equal(t, 2, adapter.commits)
+	assert.Equal(t, second, adapter.lastUpdated)
+}
+
+func TestConsumeRollback(t *testing.T) {
+	first := time.Date(2018, 3, 1, 10, 0, 0, 0, time.UTC)
+	newSource := func() Source {
+		return &BatchSource{Batches: []*Batch{{
+			Events: []*Event{
+				changeEvent(&ChangeItem{BaseIssueInfo: BaseIssueInfo{IssueKey: "HDDS-1", Created: first}}),
+				commentEvent(&CommentItem{BaseIssueInfo: BaseIssueInfo{IssueKey: "HDDS-1", Created: first}}, EventCommentAdded),
+			},
+			Cursor: first,
+		}}}
+	}
+	adapter := &memoryAdapter{commentErr: errors.New("db is down")}
+	err := consume(context.Background(), newSource(), adapter, "selector", time.Time{})
+	assert.Equal(t, exitAdapterError, exitCode(err))
+	assert.Equal(t, 1, adapter.rollbacks)
+	assert.Equal(t, 0, adapter.commits)
+	assert.True(t, adapter.lastUpdated.IsZero())
+
+	// the failed sink of a fan-out is rolled back, the other one is committed
+	db := &memoryAdapter{commentErr: errors.New("db is down")}
+	console := &memoryAdapter{}
+	err = consume(context.Background(), newSource(), NewFanOutAdapter(Sink{"db", db}, Sink{"console", console}), "selector", time.Time{})
+	assert.Equal(t, exitAdapterError, exitCode(err))
+	assert.Equal(t, 1, db.rollbacks)
+	assert.Equal(t, 0, db.commits)
+	assert.Equal(t, 0, console.rollbacks)
+	assert.Equal(t, 1, console.commits)
+}
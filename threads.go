@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+	"time"
+)
+
+// threadStoreMutex serializes the updates of the thread stores, as multiple pipelines (of the daemon) could post to
+// the same channel.
+var threadStoreMutex sync.Mutex
+
+// SlackThread is the thread of an issue in a slack channel.
+type SlackThread struct {
+	Ts      string    `json:"ts"`
+	Started time.Time `json:"started"`
+}
+
+// ThreadStore persists the slack threads of the issues of one channel.
+type ThreadStore struct {
+	FileName string
+	Threads  map[string]SlackThread
+}
+
+// threadStoreFile returns the location of the thread store of the channel.
+func threadStoreFile(channel string) string {
+	return path.Join(os.Getenv("HOME"), ".jira-retriever", "slack-"+getHash(channel)+".threads")
+}
+
+// OpenThreadStore reads the thread store from the file. A missing file is an empty store.
+func OpenThreadStore(file string) (*ThreadStore, error) {
+	store := ThreadStore{FileName: file, Threads: make(map[string]SlackThread)}
+	content, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return &store, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = decode(content, &store.Threads, "Slack thread store "+file)
+	if err != nil {
+		return nil, err
+	}
+	return &store, nil
+}
+
+// Thread returns the thread of the issue. The threads older than maxAge are not used any more (0: no limit), so a new
+// thread is started for the issue.
+func (store *ThreadStore) Thread(issueKey string, maxAge time.Duration, now time.Time) (string, bool) {
+	thread, ok := store.Threads[issueKey]
+	if !ok || (maxAge > 0 && now.Sub(thread.Started) > maxAge) {
+		return "", false
+	}
+	return thread.Ts, true
+}
+
+// Start saves the new thread of the issue.
+func (store *ThreadStore) Start(issueKey string, ts string, now time.Time) {
+	store.Threads[issueKey] = SlackThread{Ts: ts, Started: now}
+}
+
+// Save writes the store to the file. The rotated threads (older than maxAge) are removed.
+func (store *ThreadStore) Save(maxAge time.Duration, now time.Time) error {
+	for key, thread := range store.Threads {
+		if maxAge > 0 && now.Sub(thread.Started) > maxAge {
+			delete(store.Threads, key)
+		}
+	}
+	content, err := json.Marshal(store.Threads)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(path.Dir(store.FileName), os.ModePerm)
+	if err != nil {
+		return err
+	}
+	// the store is replaced at once, a failed write doesn't lose the existing threads
+	tmpFile := store.FileName + ".tmp"
+	err = ioutil.WriteFile(tmpFile, content, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpFile, store.FileName)
+}
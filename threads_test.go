@@ -0,0 +1,43 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestThreadStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jira-retriever-threads")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	file := path.Join(dir, "slack.threads")
+	now := time.Date(2018, 3, 1, 10, 0, 0, 0, time.UTC)
+
+	store, err := OpenThreadStore(file)
+	assert.Nil(t, err)
+	_, ok := store.Thread("HDDS-1", 0, now)
+	assert.False(t, ok)
+	store.Start("HDDS-1", "1519898400.000100", now.Add(-48*time.Hour))
+	store.Start("HDDS-2", "1519898400.000200", now)
+	assert.Nil(t, store.Save(0, now))
+
+	store, err = OpenThreadStore(file)
+	assert.Nil(t, err)
+	ts, ok := store.Thread("HDDS-1", 0, now)
+	assert.True(t, ok)
+	assert.Equal(t, "1519898400.000100", ts)
+
+	// the old thread is rotated
+	_, ok = store.Thread("HDDS-1", 24*time.Hour, now)
+	assert.False(t, ok)
+	assert.Nil(t, store.Save(24*time.Hour, now))
+	store, err = OpenThreadStore(file)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(store.Threads))
+	_, ok = store.Thread("HDDS-2", 24*time.Hour, now)
+	assert.True(t, ok)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Upper limit of the wait time requested by the Retry-After header of the server.
+const maxRetryAfter = 5 * time.Minute
+
+// RetryPolicy defines how the failed jira calls are repeated.
+type RetryPolicy struct {
+	// Number of the attempts including the first one.
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+var defaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 5,
+	BaseDelay:   time.Second,
+	MaxDelay:    time.Minute,
+}
+
+// backoff returns the wait time before the next attempt: exponential backoff with full jitter.
+func (policy RetryPolicy) backoff(attempt int) time.Duration {
+	delay := policy.BaseDelay << uint(attempt)
+	if delay <= 0 || delay > policy.MaxDelay {
+		delay = policy.MaxDelay
+	}
+	return time.Duration(rand.Int63n(int64(delay) + 1))
+}
+
+// retryTransport is a http.RoundTripper which repeats the requests failed with a transient error.
+type retryTransport struct {
+	base   http.RoundTripper
+	policy RetryPolicy
+}
+
+func (transport *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		content, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		body = content
+	}
+
+	attempt := 0
+	for {
+		if body != nil {
+			req.Body = ioutil.NopCloser(bytes.NewReader(body))
+		}
+		response, err := transport.base.RoundTrip(req)
+		attempt++
+		if attempt >= transport.policy.MaxAttempts {
+			return response, err
+		}
+
+		var wait time.Duration
+		if err != nil {
+			if !retryableError(err) {
+				return nil, err
+			}
+			wait = transport.policy.backoff(attempt)
+			log.Printf("Jira call %s %s is failed (%s), retrying in %s", req.Method, req.URL, err.Error(), wait)
+		} else {
+			if !retryableStatus(response.StatusCode) {
+				return response, nil
+			}
+			wait = transport.policy.backoff(attempt)
+			if retryAfter, ok := parseRetryAfter(response); ok {
+				wait = retryAfter
+			}
+			io.Copy(ioutil.Discard, response.Body)
+			response.Body.Close()
+			log.Printf("Jira call %s %s is responded with HTTP %d, retrying in %s", req.Method, req.URL,
+				response.StatusCode, wait)
+		}
+		select {
+		case <-time.After(wait):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+}
+
+// retryableStatus returns true if the status code is the sign of a temporary problem (overload, maintenance,
+// broken proxy).
+func retryableStatus(statusCode int) bool {
+	switch statusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// retryableError returns true if the transport error could be temporary (timeout, refused or reset connection).
+func retryableError(err error) bool {
+	if urlError, ok := err.(*url.Error); ok {
+		err = urlError.Err
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return true
+	}
+	if netError, ok := err.(net.Error); ok && (netError.Timeout() || netError.Temporary()) {
+		return true
+	}
+	_, opError := err.(*net.OpError)
+	return opError
+}
+
+// parseRetryAfter returns the wait time requested by the server with a 429 or 503 response.
+func parseRetryAfter(response *http.Response) (time.Duration, bool) {
+	if response.StatusCode != http.StatusTooManyRequests && response.StatusCode != http.StatusServiceUnavailable {
+		return 0, false
+	}
+	header := response.Header.Get("Retry-After")
+	if header == "" {
+		return 0, false
+	}
+	var wait time.Duration
+	if seconds, err := strconv.Atoi(header); err == nil {
+		wait = time.Duration(seconds) * time.Second
+	} else if date, err := http.ParseTime(header); err == nil {
+		wait = date.Sub(time.Now())
+	} else {
+		return 0, false
+	}
+	if wait < 0 {
+		wait = 0
+	}
+	if wait > maxRetryAfter {
+		wait = maxRetryAfter
+	}
+	return wait, true
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryTransportRepeatsTransientErrors(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if calls == 2 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte(`{"total":0}`))
+	}))
+	defer server.Close()
+
+	client := JiraClient{Url: server.URL, Retry: RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}}
+	body, err := client.queryWithParameters(context.Background(), "/search", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"total":0}`, string(body))
+	assert.Equal(t, 3, calls)
+}
+
+func TestRetryTransportReturnsErrorCollection(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"errorMessages":["Field 'foo' does not exist"]}`))
+	}))
+	defer server.Close()
+
+	client := JiraClient{Url: server.URL, Retry: RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}}
+	_, err := client.queryWithParameters(context.Background(), "/search", nil)
+	httpError, ok := err.(*HTTPError)
+	assert.True(t, ok)
+	assert.Equal(t, http.StatusBadRequest, httpError.StatusCode)
+	assert.Equal(t, "Field 'foo' does not exist", httpError.Errors.Error())
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, exitJiraError, exitCode(err))
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	response := &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{}}
+	response.Header.Set("Retry-After", "12")
+	wait, ok := parseRetryAfter(response)
+	assert.True(t, ok)
+	assert.Equal(t, 12*time.Second, wait)
+
+	response.Header.Set("Retry-After", "3600")
+	wait, _ = parseRetryAfter(response)
+	assert.Equal(t, maxRetryAfter, wait)
+
+	response.StatusCode = http.StatusBadGateway
+	_, ok = parseRetryAfter(response)
+	assert.False(t, ok)
+}
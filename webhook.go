@@ -0,0 +1,376 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/elek/jira-retriever/jiradata"
+	"github.com/spf13/cobra"
+)
+
+// maxWebhookPayload is the maximum accepted size of a webhook request.
+const maxWebhookPayload = 10 * 1024 * 1024
+
+// issueKeyPattern is the format of the issue keys accepted in the JQL of the webhook events.
+var issueKeyPattern = regexp.MustCompile(`^[A-Z][A-Z0-9_]+-\d+$`)
+
+func init() {
+	var listen string
+	var webhookCmd = &cobra.Command{
+		Use:   "webhook <pipeline>",
+		Short: "Receive jira webhook events and send them to the sinks of a pipeline",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			secret, err := resolveSecretFlag(cmd, "secret", "WEBHOOK_SECRET")
+			if err != nil {
+				return err
+			}
+			if secret == "" {
+				return errors.New("Webhook secret is required (--secret, --secret-file, --secret-command or WEBHOOK_SECRET)")
+			}
+			config, err := LoadConfig(cmd.Flag("config").Value.String())
+			if err != nil {
+				return err
+			}
+			if _, ok := config.Pipelines[args[0]]; !ok {
+				return errors.New("Unknown pipeline: " + args[0])
+			}
+			runner, err := NewPipelineRunner(config, args[0], make(map[string]*JiraClient))
+			if err != nil {
+				return err
+			}
+			defer runner.Close()
+
+			receiver := &WebhookReceiver{
+				Secret:   secret,
+				Selector: runner.Selector,
+				Client:   runner.client,
+				FanOut:   runner.FanOut,
+			}
+			server := &http.Server{Addr: listen, Handler: receiver}
+			ctx, cancel := signalContext()
+			defer cancel()
+			go func() {
+				select {
+				case <-stopChannel(ctx):
+				case <-ctx.Done():
+				}
+				server.Shutdown(context.Background())
+			}()
+			log.Print("Receiving jira webhook events at http://" + listen + "/webhook")
+			err = server.ListenAndServe()
+			if err != nil && err != http.ErrServerClosed {
+				return err
+			}
+			return nil
+		},
+	}
+	webhookCmd.Flags().StringVar(&listen, "listen", "localhost:8091", "Address of the webhook endpoint")
+	webhookCmd.Flags().String("secret", "", "Shared secret of the webhook")
+	addSecretFlags(webhookCmd.Flags(), "secret", "webhook secret")
+	rootCmd.AddCommand(webhookCmd)
+}
+
+// WebhookEvent is the payload of a jira webhook request.
+type WebhookEvent struct {
+	Timestamp    int64             `json:"timestamp"`
+	WebhookEvent string            `json:"webhookEvent"`
+	User         *jiradata.User    `json:"user"`
+	Issue        *jiradata.Issue   `json:"issue"`
+	Changelog    *WebhookChangelog `json:"changelog"`
+	Comment      *jiradata.Comment `json:"comment"`
+	Worklog      *jiradata.Worklog `json:"worklog"`
+	// IssueEventTypeName is the cause of the jira:issue_updated event, eg. issue_commented or issue_comment_edited.
+	IssueEventTypeName string `json:"issue_event_type_name"`
+}
+
+// WebhookChangelog is the change of the jira:issue_updated event.
+type WebhookChangelog struct {
+	ID    string         `json:"id"`
+	Items jiradata.Items `json:"items"`
+}
+
+// WebhookReceiver accepts the jira webhook events and sends them to the adapters as the same events as the polling.
+// The events are processed one by one.
+//
+// The requests are verified with the shared secret: either with the HMAC-SHA256 signature of the body in the
+// X-Hub-Signature header (Jira Cloud) or with the secret query parameter of the webhook url (eg.
+// /webhook?secret=...).
+//
+// The events of the issues which are not matched by the JQL of the pipeline are ignored (the issue is checked with a
+// search), so the adapters receive the same issues as by the polling.
+//
+// The last updated time is not saved, so the next polling run (eg. with the daemon) reconciles the events which are
+// missed by the webhook.
+type WebhookReceiver struct {
+	Secret   string
+	Selector string
+	// Client is used to retrieve the issue of the worklog events (which don't contain the issue) and to check the
+	// issues with the JQL of the pipeline.
+	Client *JiraClient
+	// FanOut returns the sinks which receive the events of one webhook event. A failed sink doesn't stop the others.
+	FanOut func() *FanOutAdapter
+	mutex  sync.Mutex
+}
+
+func (receiver *WebhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/webhook" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "Only POST is supported", http.StatusMethodNotAllowed)
+		return
+	}
+	content, err := ioutil.ReadAll(io.LimitReader(r.Body, maxWebhookPayload))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !verifyWebhook(receiver.Secret, r, content) {
+		log.Print("Webhook request is rejected, invalid secret or signature from " + r.RemoteAddr)
+		http.Error(w, "Invalid secret or signature", http.StatusUnauthorized)
+		return
+	}
+	var event WebhookEvent
+	err = decode(content, &event, "Webhook event")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = receiver.Handle(r.Context(), &event)
+	if err != nil {
+		log.Print("Webhook event " + event.WebhookEvent + " is failed: " + err.Error())
+		status := http.StatusInternalServerError
+		if exitCode(err) == exitDecodeError {
+			status = http.StatusBadRequest
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// verifyWebhook checks the signature header or the secret query parameter of the request.
+func verifyWebhook(secret string, r *http.Request, body []byte) bool {
+	if signature := r.Header.Get("X-Hub-Signature"); signature != "" {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		expected := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+		return hmac.Equal([]byte(signature), []byte(expected))
+	}
+	return subtle.ConstantTimeCompare([]byte(r.URL.Query().Get("secret")), []byte(secret)) == 1
+}
+
+// Handle sends the events of the webhook event to all the sinks. It returns SinkErrors if any of the sinks is failed.
+func (receiver *WebhookReceiver) Handle(ctx context.Context, event *WebhookEvent) error {
+	receiver.mutex.Lock()
+	defer receiver.mutex.Unlock()
+	events, err := receiver.events(ctx, event)
+	if err != nil || len(events) == 0 {
+		return err
+	}
+	matched, err := receiver.matches(ctx, events[0].IssueKey)
+	if err != nil {
+		return err
+	}
+	if !matched {
+		log.Print("Ignoring webhook event " + event.WebhookEvent + ", " + events[0].IssueKey +
+			" is not matched by the JQL of the pipeline")
+		return nil
+	}
+	fanOut := receiver.FanOut()
+	err = fanOut.Begin(ctx)
+	if err != nil {
+		return adapterError("Begin", err)
+	}
+	for _, item := range events {
+		err = adapterError("saveEvent", fanOut.saveEvent(ctx, item, receiver.Selector))
+		if err != nil {
+			rollback(ctx, fanOut)
+			return err
+		}
+	}
+	err = fanOut.Commit(ctx)
+	if err != nil {
+		rollback(ctx, fanOut)
+		return adapterError("Commit", err)
+	}
+	err = fanOut.Finish(ctx)
+	if err != nil {
+		return adapterError("Finish", err)
+	}
+	return fanOut.Err()
+}
+
+// matches checks if the issue is matched by the JQL of the pipeline. Only valid issue keys are added to the JQL.
+func (receiver *WebhookReceiver) matches(ctx context.Context, issueKey string) (bool, error) {
+	if receiver.Client == nil || receiver.Client.JQL == "" {
+		return true, nil
+	}
+	if !issueKeyPattern.MatchString(issueKey) {
+		return false, &DecodeError{What: "Webhook event", Err: errors.New("invalid issue key: " + issueKey)}
+	}
+	content, err := receiver.Client.queryWithParameters(ctx, "/search", url.Values{
+		"jql":        []string{"(" + receiver.Client.JQL + ") AND key = \"" + issueKey + "\""},
+		"fields":     []string{"key"},
+		"maxResults": []string{"0"},
+	})
+	if err != nil {
+		return false, err
+	}
+	var searchResults jiradata.SearchResults
+	err = decode(content, &searchResults, "Search result")
+	if err != nil {
+		return false, err
+	}
+	return searchResults.Total > 0, nil
+}
+
+// events returns the events of the webhook event.
+func (receiver *WebhookReceiver) events(ctx context.Context, event *WebhookEvent) ([]*Event, error) {
+	// every item of the event is new, nothing is filtered by time
+	var from time.Time
+	switch event.WebhookEvent {
+	case "jira:issue_created", "jira:issue_updated":
+		if event.Issue == nil {
+			return nil, &DecodeError{What: "Webhook event " + event.WebhookEvent, Err: errors.New("issue is missing")}
+		}
+		item, err := JiraFromJson(*event.Issue)
+		if err != nil {
+			return nil, err
+		}
+		// the same ids are used as by the polling: the creation or the snapshot of the update time
+		since := item.Created
+		if event.WebhookEvent == "jira:issue_created" {
+			since = time.Time{}
+		}
+		events := []*Event{issueEvent(&item, since)}
+		// the comment added (or edited) with the update is sent as the polling does
+		if event.Comment != nil {
+			comments, err := commentItems(from, event.Issue, []*jiradata.Comment{event.Comment})
+			if err != nil {
+				return nil, err
+			}
+			eventType := EventCommentAdded
+			if event.IssueEventTypeName == "issue_comment_edited" {
+				eventType = EventCommentUpdated
+			}
+			for _, comment := range comments {
+				events = append(events, commentEvent(comment, eventType))
+			}
+		}
+		if event.Changelog == nil || len(event.Changelog.Items) == 0 {
+			return receiver.withIssue(events, event.Issue), nil
+		}
+		author := event.User
+		if author == nil {
+			author = &jiradata.User{}
+		}
+		history := &jiradata.ChangeHistory{
+			ID:      event.Changelog.ID,
+			Author:  author,
+			Created: event.time().Format(timeFormat),
+			Items:   event.Changelog.Items,
+		}
+		changes, err := historyItems(from, event.Issue, jiradata.Histories{history})
+		if err != nil {
+			return nil, err
+		}
+		for _, change := range changes {
+			events = append(events, changeEvent(change))
+		}
+		return receiver.withIssue(events, event.Issue), nil
+	case "comment_created", "comment_updated":
+		if event.Comment == nil {
+			return nil, &DecodeError{What: "Webhook event " + event.WebhookEvent, Err: errors.New("comment is missing")}
+		}
+		issue, err := receiver.issueOf(ctx, event, "")
+		if err != nil {
+			return nil, err
+		}
+		comments, err := commentItems(from, issue, []*jiradata.Comment{event.Comment})
+		if err != nil || len(comments) == 0 {
+			return nil, err
+		}
+		eventType := EventCommentAdded
+		if event.WebhookEvent == "comment_updated" {
+			eventType = EventCommentUpdated
+		}
+		return receiver.withIssue([]*Event{commentEvent(comments[0], eventType)}, issue), nil
+	case "worklog_created", "worklog_updated":
+		if event.Worklog == nil {
+			return nil, &DecodeError{What: "Webhook event " + event.WebhookEvent, Err: errors.New("worklog is missing")}
+		}
+		issue, err := receiver.issueOf(ctx, event, event.Worklog.IssueID)
+		if err != nil {
+			return nil, err
+		}
+		worklogs, err := worklogItems(from, issue, jiradata.Worklogs{event.Worklog})
+		if err != nil || len(worklogs) == 0 {
+			return nil, err
+		}
+		return receiver.withIssue([]*Event{worklogEvent(worklogs[0])}, issue), nil
+	case "jira:issue_deleted", "comment_deleted", "worklog_deleted":
+		// the adapters have no delete operation, the deleted items are kept
+		log.Print("Ignoring webhook event " + event.WebhookEvent + ", deletion is not supported by the adapters")
+		return nil, nil
+	default:
+		log.Print("Ignoring unknown webhook event " + event.WebhookEvent)
+		return nil, nil
+	}
+}
+
+// withIssue sets the issue and the jira instance of the events.
+func (receiver *WebhookReceiver) withIssue(events []*Event, issue *jiradata.Issue) []*Event {
+	for _, event := range events {
+		event.Issue = issue
+		event.Jira = receiver.Client.instance()
+	}
+	return events
+}
+
+// time returns the time of the event.
+func (event *WebhookEvent) time() time.Time {
+	if event.Timestamp == 0 {
+		return time.Now()
+	}
+	return time.Unix(0, event.Timestamp*int64(time.Millisecond))
+}
+
+// issueOf returns the issue of a comment or worklog event. The issue is retrieved from jira if it's not part of the
+// event (or the summary is missing).
+func (receiver *WebhookReceiver) issueOf(ctx context.Context, event *WebhookEvent, issueId string) (*jiradata.Issue, error) {
+	if event.Issue != nil {
+		if _, ok := event.Issue.Fields["summary"].(string); ok {
+			return event.Issue, nil
+		}
+		issueId = event.Issue.ID
+	}
+	if issueId == "" {
+		return nil, &DecodeError{What: "Webhook event " + event.WebhookEvent, Err: errors.New("issue is missing")}
+	}
+	content, err := receiver.Client.queryWithParameters(ctx, "/issue/"+url.PathEscape(issueId),
+		url.Values{"fields": []string{"summary,created,updated"}})
+	if err != nil {
+		return nil, err
+	}
+	var issue jiradata.Issue
+	err = decode(content, &issue, "Issue "+issueId)
+	if err != nil {
+		return nil, err
+	}
+	return &issue, nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebhookReceiverComment(t *testing.T) {
+	adapter := NewConsoleAdapter()
+	receiver := &WebhookReceiver{
+		Secret: "secret",
+		FanOut: func() *FanOutAdapter {
+			return NewFanOutAdapter(Sink{"console", adapter})
+		},
+	}
+	payload := []byte(`{
+		"timestamp": 1520000000000,
+		"webhookEvent": "comment_created",
+		"issue": {"id": "10001", "key": "HDDS-1", "fields": {"summary": "Test issue"}},
+		"comment": {"id": "42", "body": "LGTM", "created": "2018-03-02T14:13:20.000+0000",
+			"author": {"key": "elek", "displayName": "Marton Elek"}}
+	}`)
+
+	request := httptest.NewRequest("POST", "/webhook", bytes.NewReader(payload))
+	request.Header.Set("X-Hub-Signature", "sha256=invalid")
+	response := httptest.NewRecorder()
+	receiver.ServeHTTP(response, request)
+	assert.Equal(t, http.StatusUnauthorized, response.Code)
+	assert.Equal(t, 0, len(adapter.Events))
+
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write(payload)
+	request = httptest.NewRequest("POST", "/webhook", bytes.NewReader(payload))
+	request.Header.Set("X-Hub-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	response = httptest.NewRecorder()
+	receiver.ServeHTTP(response, request)
+	assert.Equal(t, http.StatusNoContent, response.Code)
+	assert.Equal(t, 1, len(adapter.Events))
+	assert.Equal(t, EventCommentAdded, adapter.Events[0].Type)
+	assert.Equal(t, "comment:42", adapter.Events[0].ID)
+	assert.Equal(t, "Marton Elek", adapter.Events[0].Actor.DisplayName)
+	comment := adapter.Events[0].Payload.(*CommentItem)
+	assert.Equal(t, "HDDS-1", comment.IssueKey)
+	assert.Equal(t, "LGTM", comment.Comment.Body)
+}
+
+func TestWebhookReceiverPipelineJQL(t *testing.T) {
+	var queries []string
+	client := fakeClient(func(req *http.Request) (int, string) {
+		jql := req.URL.Query().Get("jql")
+		queries = append(queries, jql)
+		if strings.Contains(jql, "HDDS-1") {
+			return http.StatusOK, `{"total":1}`
+		}
+		return http.StatusOK, `{"total":0}`
+	})
+	client.JQL = "project = HDDS"
+	adapter := &memoryAdapter{}
+	receiver := &WebhookReceiver{
+		Secret: "secret",
+		Client: client,
+		FanOut: func() *FanOutAdapter {
+			return NewFanOutAdapter(Sink{"memory", adapter})
+		},
+	}
+	send := func(payload string) int {
+		request := httptest.NewRequest("POST", "/webhook?secret=secret", strings.NewReader(payload))
+		response := httptest.NewRecorder()
+		receiver.ServeHTTP(response, request)
+		return response.Code
+	}
+
+	issue := `{"webhookEvent": "jira:issue_updated", "issue": {"key": "%s", "fields": {"summary": "Test issue",
+		"created": "2018-03-01T10:00:00.000+0000", "updated": "2018-03-02T10:00:00.000+0000"}}}`
+	assert.Equal(t, http.StatusNoContent, send(strings.Replace(issue, "%s", "HADOOP-1", 1)))
+	assert.Equal(t, 0, len(adapter.events))
+	assert.Equal(t, http.StatusNoContent, send(strings.Replace(issue, "%s", "HDDS-1", 1)))
+	assert.Equal(t, 1, len(adapter.events))
+	assert.Equal(t, []string{`(project = HDDS) AND key = "HADOOP-1"`, `(project = HDDS) AND key = "HDDS-1"`}, queries)
+
+	// the required fields are missing
+	assert.Equal(t, http.StatusBadRequest, send(`{"webhookEvent": "jira:issue_updated", "issue": {"key": "HDDS-1"}}`))
+	assert.Equal(t, 1, len(adapter.events))
+
+	// the invalid issue keys are not added to the JQL
+	assert.Equal(t, http.StatusBadRequest, send(strings.Replace(issue, "%s", `HDDS-1\" OR project = HADOOP`, 1)))
+	assert.Equal(t, 2, len(queries))
+	assert.Equal(t, 1, len(adapter.events))
+}
+
+func TestWebhookReceiverIssueComment(t *testing.T) {
+	adapter := &memoryAdapter{}
+	receiver := &WebhookReceiver{
+		Secret: "secret",
+		FanOut: func() *FanOutAdapter {
+			return NewFanOutAdapter(Sink{"memory", adapter})
+		},
+	}
+	payload := `{"webhookEvent": "jira:issue_updated", "issue_event_type_name": "issue_commented",
+		"issue": {"key": "HDDS-1", "fields": {"summary": "Test issue",
+			"created": "2018-03-01T10:00:00.000+0000", "updated": "2018-03-02T10:00:00.000+0000"}},
+		"comment": {"id": "42", "body": "LGTM", "created": "2018-03-02T10:00:00.000+0000",
+			"author": {"key": "elek", "displayName": "Marton Elek"}}}`
+	request := httptest.NewRequest("POST", "/webhook?secret=secret", strings.NewReader(payload))
+	response := httptest.NewRecorder()
+	receiver.ServeHTTP(response, request)
+
+	assert.Equal(t, http.StatusNoContent, response.Code)
+	assert.Equal(t, 2, len(adapter.events))
+	assert.Equal(t, EventIssueUpdated, adapter.events[0].Type)
+	assert.Equal(t, EventCommentAdded, adapter.events[1].Type)
+	assert.Equal(t, "comment:42", adapter.events[1].ID)
+	assert.Equal(t, "LGTM", adapter.events[1].Payload.(*CommentItem).Comment.Body)
+}
+
+func TestWebhookReceiverFailedSink(t *testing.T) {
+	failing := &memoryAdapter{commentErr: errors.New("db is down")}
+	adapter := &memoryAdapter{}
+	receiver := &WebhookReceiver{
+		Secret: "secret",
+		FanOut: func() *FanOutAdapter {
+			return NewFanOutAdapter(Sink{"db", failing}, Sink{"memory", adapter})
+		},
+	}
+	payload := `{"webhookEvent": "comment_created",
+		"issue": {"id": "10001", "key": "HDDS-1", "fields": {"summary": "Test issue"}},
+		"comment": {"id": "42", "body": "LGTM", "created": "2018-03-02T14:13:20.000+0000"}}`
+	request := httptest.NewRequest("POST", "/webhook?secret=secret", strings.NewReader(payload))
+	response := httptest.NewRecorder()
+	receiver.ServeHTTP(response, request)
+
+	// the other sinks receive the event
+	assert.Equal(t, http.StatusInternalServerError, response.Code)
+	assert.Contains(t, response.Body.String(), "db is down")
+	assert.Equal(t, 1, failing.rollbacks)
+	assert.Equal(t, 0, failing.commits)
+	assert.Equal(t, 1, len(adapter.events))
+	assert.Equal(t, 1, adapter.commits)
+}
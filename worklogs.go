@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/elek/jira-retriever/jiradata"
+)
+
+// The updated worklog feed is global (not limited by the JQL), it's used only if the run starts from a recent point
+// in time. Otherwise the worklogs are paged issue by issue.
+const worklogFeedWindow = 7 * 24 * time.Hour
+
+// Maximum number of ids accepted by the /worklog/list endpoint.
+const worklogListBatch = 1000
+
+// UpdatedWorklogPage is one page of the /worklog/updated endpoint.
+type UpdatedWorklogPage struct {
+	Since    int64 `json:"since,omitempty"`
+	Until    int64 `json:"until,omitempty"`
+	LastPage bool  `json:"lastPage,omitempty"`
+	Values   []struct {
+		WorklogID   int   `json:"worklogId,omitempty"`
+		UpdatedTime int64 `json:"updatedTime,omitempty"`
+	} `json:"values,omitempty"`
+}
+
+// WorklogFeed contains all the worklogs updated since a given time grouped by the id of the issue. It's loaded
+// lazily, only when an issue with truncated worklog list is found.
+type WorklogFeed struct {
+	mutex   sync.Mutex
+	client  *JiraClient
+	since   time.Time
+	loaded  bool
+	byIssue map[string]jiradata.Worklogs
+}
+
+// NewWorklogFeed returns the updated worklog feed or nil if the since time is too old to use the global feed.
+func NewWorklogFeed(client *JiraClient, since time.Time) *WorklogFeed {
+	if time.Since(since) > worklogFeedWindow {
+		return nil
+	}
+	return &WorklogFeed{client: client, since: since}
+}
+
+func (feed *WorklogFeed) forIssue(ctx context.Context, issueID string) (jiradata.Worklogs, error) {
+	feed.mutex.Lock()
+	defer feed.mutex.Unlock()
+	if !feed.loaded {
+		err := feed.load(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return feed.byIssue[issueID], nil
+}
+
+func (feed *WorklogFeed) load(ctx context.Context) error {
+	ids := make([]int, 0)
+	sinceMs := feed.since.UnixNano() / 1000000
+	for {
+		parameters := url.Values{"since": []string{strconv.FormatInt(sinceMs, 10)}}
+		content, err := feed.client.queryWithParameters(ctx, "/worklog/updated", parameters)
+		if err != nil {
+			return err
+		}
+		var page UpdatedWorklogPage
+		err = decode(content, &page, "Updated worklogs")
+		if err != nil {
+			return err
+		}
+		for _, value := range page.Values {
+			ids = append(ids, value.WorklogID)
+		}
+		if page.LastPage || len(page.Values) == 0 || page.Until <= sinceMs {
+			break
+		}
+		sinceMs = page.Until
+	}
+	log.Printf("%d worklogs are updated since %s", len(ids), feed.since.Format(time.RFC3339))
+
+	feed.byIssue = make(map[string]jiradata.Worklogs)
+	for start := 0; start < len(ids); start += worklogListBatch {
+		end := start + worklogListBatch
+		if end > len(ids) {
+			end = len(ids)
+		}
+		content, err := feed.client.post(ctx, "/worklog/list", map[string][]int{"ids": ids[start:end]})
+		if err != nil {
+			return err
+		}
+		var worklogs jiradata.Worklogs
+		err = decode(content, &worklogs, "Worklogs")
+		if err != nil {
+			return err
+		}
+		for _, worklog := range worklogs {
+			feed.byIssue[worklog.IssueID] = append(feed.byIssue[worklog.IssueID], worklog)
+		}
+	}
+	feed.loaded = true
+	return nil
+}
+
+// issueWorklogs returns the worklogs of the issue which could be updated after the since time. The worklog page
+// embedded to the search results is truncated by jira, in this case the missing worklogs are retrieved from the
+// updated worklog feed (if available) or from the worklog endpoint of the issue.
+func issueWorklogs(ctx context.Context, client *JiraClient, feed *WorklogFeed, issue *jiradata.Issue) (jiradata.Worklogs, error) {
+	var embedded jiradata.WorklogWithPagination
+	err := convert(issue.Fields["worklog"], &embedded, "Worklogs of "+issue.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	worklogs := embedded.Worklogs
+	if embedded.Total <= len(worklogs) {
+		return worklogs, nil
+	}
+
+	seen := make(map[string]bool)
+	for _, worklog := range worklogs {
+		seen[worklog.ID] = true
+	}
+	add := func(page jiradata.Worklogs) {
+		for _, worklog := range page {
+			if !seen[worklog.ID] {
+				seen[worklog.ID] = true
+				worklogs = append(worklogs, worklog)
+			}
+		}
+	}
+
+	if feed != nil {
+		updated, err := feed.forIssue(ctx, issue.ID)
+		if err != nil {
+			return nil, err
+		}
+		add(updated)
+		return worklogs, nil
+	}
+
+	log.Printf("%s has %d worklogs but only %d are embedded, retrieving the remaining ones",
+		issue.Key, embedded.Total, len(worklogs))
+	startAt := 0
+	for startAt < embedded.Total {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		parameters := url.Values{"startAt": []string{strconv.Itoa(startAt)}}
+		if client.PageSize > 0 {
+			parameters.Set("maxResults", strconv.Itoa(client.PageSize))
+		}
+		content, err := client.queryWithParameters(ctx, "/issue/"+issue.Key+"/worklog", parameters)
+		if err != nil {
+			return nil, err
+		}
+		var page jiradata.WorklogWithPagination
+		err = decode(content, &page, "Worklogs of "+issue.Key)
+		if err != nil {
+			return nil, err
+		}
+		if len(page.Worklogs) == 0 {
+			break
+		}
+		add(page.Worklogs)
+		next := page.StartAt + len(page.Worklogs)
+		// a short page is the last one, a page which doesn't move forward (startAt is ignored) too
+		if next <= startAt || (page.MaxResults > 0 && len(page.Worklogs) < page.MaxResults) {
+			break
+		}
+		startAt = next
+	}
+	return worklogs, nil
+}
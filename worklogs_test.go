@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/elek/jira-retriever/jiradata"
+	"github.com/stretchr/testify/assert"
+)
+
+func worklogIds(worklogs jiradata.Worklogs) []string {
+	ids := []string{}
+	for _, worklog := range worklogs {
+		ids = append(ids, worklog.ID)
+	}
+	return ids
+}
+
+// worklogPage returns a worklog page with the ids from first to last.
+func worklogPage(startAt int, maxResults int, total int, first int, last int) string {
+	var worklogs []string
+	for id := first; id <= last; id++ {
+		worklogs = append(worklogs, fmt.Sprintf(`{"id": "%d"}`, id))
+	}
+	return fmt.Sprintf(`{"startAt": %d, "maxResults": %d, "total": %d, "worklogs": [%s]}`,
+		startAt, maxResults, total, strings.Join(worklogs, ", "))
+}
+
+func TestIssueWorklogs(t *testing.T) {
+	ids := func(first int, last int) []string {
+		result := []string{}
+		for id := first; id <= last; id++ {
+			result = append(result, strconv.Itoa(id))
+		}
+		return result
+	}
+	cases := []struct {
+		name     string
+		embedded string
+		// the pages of the worklog endpoint by startAt
+		pages  map[int]string
+		starts []int
+		ids    []string
+	}{
+		{
+			name:     "not truncated",
+			embedded: `{"total": 2, "worklogs": [{"id": "1"}, {"id": "2"}]}`,
+			ids:      []string{"1", "2"},
+		},
+		{
+			name:     "truncated",
+			embedded: `{"total": 5, "worklogs": [{"id": "1"}, {"id": "2"}]}`,
+			pages: map[int]string{
+				0: worklogPage(0, 3, 5, 1, 3),
+				3: worklogPage(3, 3, 5, 4, 5),
+			},
+			starts: []int{0, 3},
+			ids:    []string{"1", "2", "3", "4", "5"},
+		},
+		{
+			name:     "empty page",
+			embedded: `{"total": 5, "worklogs": [{"id": "1"}]}`,
+			pages:    map[int]string{0: `{"startAt": 0, "maxResults": 3, "total": 5}`},
+			starts:   []int{0},
+			ids:      []string{"1"},
+		},
+		{
+			name:     "short page",
+			embedded: `{"total": 5, "worklogs": [{"id": "1"}]}`,
+			pages:    map[int]string{0: worklogPage(0, 50, 5, 1, 2)},
+			starts:   []int{0},
+			ids:      []string{"1", "2"},
+		},
+		{
+			name:     "startAt is ignored",
+			embedded: `{"total": 30, "worklogs": [{"id": "1"}]}`,
+			pages: map[int]string{
+				0:  worklogPage(0, 0, 30, 1, 29),
+				29: worklogPage(0, 0, 30, 1, 29),
+			},
+			starts: []int{0, 29},
+			ids:    ids(1, 29),
+		},
+	}
+	for _, c := range cases {
+		var starts []int
+		client := fakeClient(func(req *http.Request) (int, string) {
+			startAt, _ := strconv.Atoi(req.URL.Query().Get("startAt"))
+			starts = append(starts, startAt)
+			return http.StatusOK, c.pages[startAt]
+		})
+		var embedded interface{}
+		assert.Nil(t, decode([]byte(c.embedded), &embedded, c.name))
+		issue := &jiradata.Issue{Key: "HDDS-1", Fields: map[string]interface{}{"worklog": embedded}}
+
+		worklogs, err := issueWorklogs(context.Background(), client, nil, issue)
+		assert.Nil(t, err, c.name)
+		assert.Equal(t, c.ids, worklogIds(worklogs), c.name)
+		assert.Equal(t, c.starts, starts, c.name)
+	}
+}
+
+func TestIssueWorklogsCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	client := fakeClient(func(req *http.Request) (int, string) {
+		calls++
+		cancel()
+		startAt, _ := strconv.Atoi(req.URL.Query().Get("startAt"))
+		return http.StatusOK, worklogPage(startAt, 1, 30, startAt+1, startAt+1)
+	})
+	var embedded interface{}
+	assert.Nil(t, decode([]byte(`{"total": 30, "worklogs": [{"id": "1"}]}`), &embedded, "embedded"))
+	issue := &jiradata.Issue{Key: "HDDS-1", Fields: map[string]interface{}{"worklog": embedded}}
+
+	_, err := issueWorklogs(ctx, client, nil, issue)
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, 1, calls)
+}